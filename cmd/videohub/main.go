@@ -0,0 +1,261 @@
+// Command videohub controls a Blackmagic Videohub from the command line.
+//
+// Usage:
+//
+//	videohub -ip 10.0.0.5 route 3 7
+//	videohub -ip 10.0.0.5 label input 2 "CAM 2"
+//	videohub -ip 10.0.0.5 status
+//	videohub -ip 10.0.0.5 watch
+//	videohub -ip 10.0.0.5 save presets.json [name]
+//	videohub -ip 10.0.0.5 load presets.json [name]
+//
+// Ports are zero-based indexes, as in the protocol, or labels.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/StechLabs/pydeohub/videohub"
+)
+
+const usage = `Usage: videohub [flags] <command> [arguments]
+
+Commands:
+  route <output> <input>              Route input to output
+  label input|output <index> <label>  Set a label
+  status                              Show the routing table
+  watch                               Print changes as they happen
+  save <file> [name]                  Save the routing as a preset (default name "default")
+  load <file> [name]                  Apply a saved preset
+
+Flags:
+`
+
+func main() {
+	ip := flag.String("ip", os.Getenv("VIDEOHUB_IP"), "address of the Videohub (default $VIDEOHUB_IP)")
+	port := flag.Int("port", videohub.DefaultPort, "TCP port")
+	timeout := flag.Duration("timeout", 5*time.Second, "time to wait for the connection and each command")
+	verbose := flag.Bool("v", false, "log protocol traffic")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *ip == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	level := slog.LevelWarn
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	vh, err := videohub.NewVideohubContext(ctx, *ip,
+		videohub.WithPort(*port),
+		videohub.WithDialTimeout(*timeout),
+		videohub.WithCommandTimeout(*timeout),
+		videohub.WithLogLevel(level),
+	)
+	if err == nil {
+		err = vh.WaitReady(ctx)
+	}
+	cancel()
+	if err != nil {
+		fatal(err)
+	}
+	defer vh.Close()
+
+	if err := run(vh, flag.Arg(0), flag.Args()[1:]); err != nil {
+		vh.Close()
+		fatal(err)
+	}
+}
+
+func run(vh *videohub.Videohub, command string, args []string) error {
+	switch command {
+	case "route":
+		if len(args) != 2 {
+			return errors.New("usage: route <output> <input>")
+		}
+		output, err := port(vh.OutputIndexByLabel, args[0])
+		if err != nil {
+			return err
+		}
+		input, err := port(vh.InputIndexByLabel, args[1])
+		if err != nil {
+			return err
+		}
+		return vh.Route(output, input)
+	case "label":
+		if len(args) != 3 {
+			return errors.New("usage: label input|output <index> <label>")
+		}
+		switch args[0] {
+		case "input":
+			index, err := port(vh.InputIndexByLabel, args[1])
+			if err != nil {
+				return err
+			}
+			return vh.InputLabel(index, args[2])
+		case "output":
+			index, err := port(vh.OutputIndexByLabel, args[1])
+			if err != nil {
+				return err
+			}
+			return vh.OutputLabel(index, args[2])
+		}
+		return fmt.Errorf("unknown port kind %q, want input or output", args[0])
+	case "status":
+		return status(vh)
+	case "watch":
+		return watch(vh)
+	case "save":
+		file, name, err := presetArgs(args)
+		if err != nil {
+			return err
+		}
+		presets, err := videohub.LoadPresets(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			presets, err = videohub.Presets{}, nil
+		}
+		if err != nil {
+			return err
+		}
+		presets[name] = vh.CapturePreset(name)
+		return presets.Save(file)
+	case "load":
+		file, name, err := presetArgs(args)
+		if err != nil {
+			return err
+		}
+		presets, err := videohub.LoadPresets(file)
+		if err != nil {
+			return err
+		}
+		preset, ok := presets[name]
+		if !ok {
+			return fmt.Errorf("no preset %q in %s", name, file)
+		}
+		report, err := vh.ApplyPreset(preset)
+		for _, c := range report.Changed {
+			fmt.Printf("output %d: input %d -> %d\n", c.Destination, c.From, c.To)
+		}
+		fmt.Printf("%d changed, %d unchanged, %d locked, %d invalid\n",
+			len(report.Changed), report.Unchanged, len(report.Locked), len(report.Invalid))
+		return err
+	}
+	return fmt.Errorf("unknown command %q", command)
+}
+
+// port parses a zero-based index or looks the argument up as a label.
+func port(byLabel func(string, ...videohub.MatchOption) (int, error), arg string) (int, error) {
+	if i, err := strconv.Atoi(arg); err == nil {
+		return i, nil
+	}
+	return byLabel(arg, videohub.WithPrefixMatch())
+}
+
+func presetArgs(args []string) (file, name string, err error) {
+	switch len(args) {
+	case 1:
+		return args[0], "default", nil
+	case 2:
+		return args[0], args[1], nil
+	}
+	return "", "", errors.New("usage: save|load <file> [name]")
+}
+
+func status(vh *videohub.Videohub) error {
+	info := vh.DeviceInfo()
+	fmt.Printf("%s (%s), protocol %s, %d inputs, %d outputs\n\n",
+		info.Model, info.UniqueID, info.ProtocolVersion, info.Inputs, info.Outputs)
+	inputs, outputs, locks := vh.InputLabels(), vh.OutputLabels(), vh.Locks()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OUTPUT\tLABEL\tINPUT\tLABEL\tLOCK")
+	for o, i := range vh.Routing() {
+		source := "?"
+		if i >= 0 && i < len(inputs) {
+			source = inputs[i]
+		}
+		lock := ""
+		if o < len(locks) && locks[o] != videohub.LockUnlocked {
+			lock = locks[o]
+		}
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\n", o, outputs[o], i, source, lock)
+	}
+	return w.Flush()
+}
+
+func watch(vh *videohub.Videohub) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	events, cancel := vh.Subscribe()
+	defer cancel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			printEvent(vh, ev)
+		}
+	}
+}
+
+func printEvent(vh *videohub.Videohub, ev videohub.Event) {
+	now := time.Now().Format(time.TimeOnly)
+	switch ev := ev.(type) {
+	case videohub.RouteChange:
+		fmt.Printf("%s route  output %d (%s): input %d -> %d (%s)\n", now,
+			ev.Destination, label(vh.OutputLabels(), ev.Destination),
+			ev.Previous, ev.Source, label(vh.InputLabels(), ev.Source))
+	case videohub.LabelChange:
+		fmt.Printf("%s label  %s %d: %q -> %q\n", now, ev.Kind, ev.Index, ev.Previous, ev.Label)
+	case videohub.LockChange:
+		fmt.Printf("%s lock   output %d (%s): %s -> %s\n", now,
+			ev.Destination, label(vh.OutputLabels(), ev.Destination), ev.Previous, ev.State)
+	case videohub.ForcedUnlock:
+		fmt.Printf("%s unlock output %d (%s): forced, was %s\n", now,
+			ev.Destination, label(vh.OutputLabels(), ev.Destination), ev.Previous)
+	case videohub.ConnectionChange:
+		fmt.Printf("%s state  %s -> %s\n", now, ev.Old, ev.New)
+	case videohub.DeviceChange:
+		fmt.Printf("%s device %s -> %s\n", now, ev.OldID, ev.NewID)
+	case videohub.DimensionsChange:
+		fmt.Printf("%s size   %dx%d -> %dx%d\n", now, ev.OldInputs, ev.OldOutputs, ev.Inputs, ev.Outputs)
+	case videohub.PowerSupplyFault:
+		fmt.Printf("%s alarm  power supply %d: %s\n", now, ev.PowerSupply.Index+1, ev.PowerSupply.Status)
+	case videohub.KeepaliveFailed:
+		fmt.Printf("%s ping   no answer (last seen %s): %v\n", now, ev.LastSeen.Format(time.TimeOnly), ev.Err)
+	}
+}
+
+func label(labels []string, i int) string {
+	if i < 0 || i >= len(labels) {
+		return "?"
+	}
+	return labels[i]
+}
+
+func fatal(err error) {
+	msg := err.Error()
+	if !strings.HasPrefix(msg, "videohub: ") {
+		msg = "videohub: " + msg
+	}
+	fmt.Fprintln(os.Stderr, msg)
+	os.Exit(1)
+}
@@ -0,0 +1,134 @@
+package videohub
+
+import (
+	"strings"
+)
+
+// PowerSupply is the last reported state of one power supply of a frame with
+// redundant power, such as the Universal Videohub.
+type PowerSupply struct {
+	Index   int    `json:"index"`   // Zero-based supply index
+	Present bool   `json:"present"` // Supply is fitted
+	OK      bool   `json:"ok"`      // Supply is fitted and reports no fault
+	Status  string `json:"status"`  // Raw status text as sent by the device (ex. 'OK')
+}
+
+// PowerSupplies returns the power supplies reported in the ALARM STATUS
+// block. It is empty for devices without redundant power.
+func (vh *Videohub) PowerSupplies() []PowerSupply {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return append([]PowerSupply(nil), vh.powerSupplies...)
+}
+
+// OnPowerSupplyFault registers fn to be called when a power supply that was
+// reported OK starts reporting a failure or is removed. Supplies that are
+// already faulty or missing in the first report do not trigger it.
+func (vh *Videohub) OnPowerSupplyFault(fn func(ps PowerSupply)) {
+	vh.handlersMu.Lock()
+	defer vh.handlersMu.Unlock()
+	vh.powerHandlers = append(vh.powerHandlers, fn)
+}
+
+// Alarm is one entry of the ALARM STATUS block, such as a power supply, fan
+// or temperature sensor.
+type Alarm struct {
+	Name   string `json:"name"`   // Alarm name as sent by the device (ex. 'Fan 1')
+	Status string `json:"status"` // Raw status text (ex. 'OK')
+	OK     bool   `json:"ok"`     // Status reports no fault
+}
+
+// Alarms returns every entry of the ALARM STATUS block in the order the device
+// first reported them. It is empty for devices that do not send the block.
+func (vh *Videohub) Alarms() []Alarm {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return append([]Alarm(nil), vh.alarms...)
+}
+
+func (vh *Videohub) processAlarmStatus(contents []string) {
+	vh.mu.Lock()
+	var faults []PowerSupply
+	for _, item := range contents {
+		parts := strings.SplitN(item, ": ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		vh.updateAlarm(Alarm{Name: parts[0], Status: parts[1], OK: statusOK(parts[1])})
+		i, ok := powerSupplyIndex(parts[0])
+		if !ok {
+			continue
+		}
+		for len(vh.powerSupplies) <= i {
+			vh.powerSupplies = append(vh.powerSupplies, PowerSupply{Index: len(vh.powerSupplies)})
+		}
+		old := vh.powerSupplies[i]
+		ps := parsePowerSupply(i, parts[1])
+		vh.powerSupplies[i] = ps
+		// The first report is the baseline: a supply that is already
+		// missing or faulty is not news on every connect.
+		if old.Status != "" && old.OK && !ps.OK {
+			faults = append(faults, ps)
+		}
+	}
+	vh.mu.Unlock()
+
+	if len(faults) == 0 {
+		return
+	}
+	vh.handlersMu.Lock()
+	handlers := append([]func(ps PowerSupply){}, vh.powerHandlers...)
+	vh.handlersMu.Unlock()
+	for _, ps := range faults {
+		vh.logger.Warn("Power supply fault", "supply", ps.Index+1, "status", ps.Status)
+		for _, fn := range handlers {
+			fn(ps)
+		}
+		vh.publish(PowerSupplyFault{PowerSupply: ps})
+	}
+}
+
+// updateAlarm replaces the alarm with the same name or appends a new one. The
+// caller must hold vh.mu.
+func (vh *Videohub) updateAlarm(alarm Alarm) {
+	for i := range vh.alarms {
+		if vh.alarms[i].Name == alarm.Name {
+			vh.alarms[i] = alarm
+			return
+		}
+	}
+	vh.alarms = append(vh.alarms, alarm)
+}
+
+func statusOK(status string) bool {
+	switch strings.ToLower(strings.TrimSpace(status)) {
+	case "ok", "good", "normal":
+		return true
+	}
+	return false
+}
+
+// powerSupplyIndex extracts the zero-based index from keys such as
+// 'Power supply 1' or 'PSU 2'.
+func powerSupplyIndex(key string) (int, bool) {
+	lower := strings.ToLower(key)
+	for _, prefix := range []string{"power supply ", "psu "} {
+		if n, ok := strings.CutPrefix(lower, prefix); ok {
+			i, ok := parseIndex(n)
+			if !ok || i == 0 {
+				return 0, false
+			}
+			return i - 1, true
+		}
+	}
+	return 0, false
+}
+
+func parsePowerSupply(index int, status string) PowerSupply {
+	ps := PowerSupply{Index: index, Status: status, Present: true, OK: statusOK(status)}
+	switch strings.ToLower(strings.TrimSpace(status)) {
+	case "not present", "absent", "removed", "none":
+		ps.Present = false
+	}
+	return ps
+}
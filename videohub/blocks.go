@@ -0,0 +1,153 @@
+package videohub
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// BlockType identifies a protocol block by its header, without the trailing
+// colon.
+type BlockType string
+
+const (
+	BlockProtocolPreamble        BlockType = "PROTOCOL PREAMBLE"
+	BlockVideohubDevice          BlockType = "VIDEOHUB DEVICE"
+	BlockInputLabels             BlockType = "INPUT LABELS"
+	BlockOutputLabels            BlockType = "OUTPUT LABELS"
+	BlockVideoOutputLocks        BlockType = "VIDEO OUTPUT LOCKS"
+	BlockVideoOutputRouting      BlockType = "VIDEO OUTPUT ROUTING"
+	BlockMonitoringOutputLabels  BlockType = "MONITORING OUTPUT LABELS"
+	BlockMonitoringOutputRouting BlockType = "MONITORING OUTPUT ROUTING"
+	BlockSerialPortLabels        BlockType = "SERIAL PORT LABELS"
+	BlockSerialPortRouting       BlockType = "SERIAL PORT ROUTING"
+	BlockSerialPortDirections    BlockType = "SERIAL PORT DIRECTIONS"
+	BlockSerialPortLocks         BlockType = "SERIAL PORT LOCKS"
+	BlockProcessingUnitRouting   BlockType = "PROCESSING UNIT ROUTING"
+	BlockFrameLabels             BlockType = "FRAME LABELS"
+	BlockFrameBufferRouting      BlockType = "FRAME BUFFER ROUTING"
+	BlockConfiguration           BlockType = "CONFIGURATION"
+	BlockTakeMode                BlockType = "TAKE MODE"
+	BlockAlarmStatus             BlockType = "ALARM STATUS"
+	BlockEndPrelude              BlockType = "END PRELUDE" // Sent after the initial dump by protocol 2.8 and later
+)
+
+// initialDumpBlocks are the blocks the device sends on connect that must all
+// have been received before the Videohub is considered ready.
+var initialDumpBlocks = []BlockType{
+	BlockProtocolPreamble,
+	BlockVideohubDevice,
+	BlockInputLabels,
+	BlockOutputLabels,
+	BlockVideoOutputRouting,
+}
+
+type rawBlock struct {
+	header string
+	lines  []string
+}
+
+// LastBlock returns the most recently received block of type t exactly as the
+// device sent it, split into its header line and body lines. ok is false if no
+// such block has been received.
+func (vh *Videohub) LastBlock(t BlockType) (header string, lines []string, ok bool) {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	block, ok := vh.lastBlocks[t]
+	if !ok {
+		return "", nil, false
+	}
+	return block.header, append([]string(nil), block.lines...), true
+}
+
+func (vh *Videohub) storeBlock(t BlockType, header string, lines []string) {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	if vh.lastBlocks == nil {
+		vh.lastBlocks = make(map[BlockType]rawBlock)
+	}
+	vh.lastBlocks[t] = rawBlock{header: header, lines: append([]string(nil), lines...)}
+}
+
+// RegisterBlockHandler registers fn to be called with the body lines of every
+// block of type t the device sends, after any parsing of its own. This lets
+// callers handle blocks added by newer firmware that this package does not
+// know yet. fn runs on the reader goroutine, so it must not block or wait for
+// a command to be acknowledged.
+func (vh *Videohub) RegisterBlockHandler(t BlockType, fn func(lines []string)) {
+	vh.handlersMu.Lock()
+	defer vh.handlersMu.Unlock()
+	if vh.blockHandlers == nil {
+		vh.blockHandlers = make(map[BlockType][]func(lines []string))
+	}
+	vh.blockHandlers[t] = append(vh.blockHandlers[t], fn)
+}
+
+// WithBlockHandler registers fn as with RegisterBlockHandler before
+// connecting, so that it also sees the blocks of the initial dump.
+func WithBlockHandler(t BlockType, fn func(lines []string)) Option {
+	return func(vh *Videohub) {
+		vh.RegisterBlockHandler(t, fn)
+	}
+}
+
+func (vh *Videohub) hasBlockHandler(t BlockType) bool {
+	vh.handlersMu.Lock()
+	defer vh.handlersMu.Unlock()
+	return len(vh.blockHandlers[t]) > 0
+}
+
+func (vh *Videohub) runBlockHandlers(t BlockType, lines []string) {
+	vh.handlersMu.Lock()
+	handlers := append([]func(lines []string){}, vh.blockHandlers[t]...)
+	vh.handlersMu.Unlock()
+	for _, fn := range handlers {
+		fn(append([]string(nil), lines...))
+	}
+}
+
+// markDumpBlock records that a block of the initial dump has arrived. Once
+// all of them have, or the device has marked the end of the dump with END
+// PRELUDE, it signals WaitReady, clears the stale flag set by a reconnect and
+// locks again the outputs held before it.
+func (vh *Videohub) markDumpBlock(t BlockType) {
+	vh.mu.Lock()
+	if vh.dumpSeen == nil {
+		vh.dumpSeen = make(map[BlockType]bool)
+	}
+	vh.dumpSeen[t] = true
+	for _, t := range initialDumpBlocks {
+		if !vh.dumpSeen[t] && !vh.dumpSeen[BlockEndPrelude] {
+			vh.mu.Unlock()
+			return
+		}
+	}
+	vh.stale = false
+	relock := vh.takeRelock()
+	vh.mu.Unlock()
+	vh.readyOnce.Do(func() { close(vh.ready) })
+	if len(relock) > 0 {
+		go vh.relockAfterReconnect(relock)
+	}
+}
+
+// WaitReady blocks until the device has sent its initial state dump
+// (preamble, device information, labels and routing), ctx is done or the
+// Videohub is closed.
+func (vh *Videohub) WaitReady(ctx context.Context) error {
+	select {
+	case <-vh.ready:
+		return nil
+	case <-vh.ctx.Done():
+		return ErrClosed
+	case <-ctx.Done():
+		return fmt.Errorf("videohub: initial state not received: %w", ctx.Err())
+	}
+}
+
+// WaitForReady is WaitReady bounded by timeout.
+func (vh *Videohub) WaitForReady(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return vh.WaitReady(ctx)
+}
@@ -0,0 +1,80 @@
+package videohub
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotReady is returned by commands sent before the device has
+	// reported its dimensions.
+	ErrNotReady = errors.New("videohub: device information not received yet")
+	// ErrInputOutOfRange is returned for an input the device does not have.
+	ErrInputOutOfRange = errors.New("videohub: input out of range")
+	// ErrOutputOutOfRange is returned for an output or monitoring output the
+	// device does not have.
+	ErrOutputOutOfRange = errors.New("videohub: output out of range")
+	// ErrSerialPortOutOfRange is returned for a serial port the device does
+	// not have.
+	ErrSerialPortOutOfRange = errors.New("videohub: serial port out of range")
+)
+
+func (vh *Videohub) checkInput(source int) error {
+	return vh.checkRange(ErrInputOutOfRange, "input", source, func() int { return vh.inputs })
+}
+
+func (vh *Videohub) checkOutput(destination int) error {
+	return vh.checkRange(ErrOutputOutOfRange, "output", destination, func() int { return vh.outputs })
+}
+
+func (vh *Videohub) checkMonitoringOutput(destination int) error {
+	return vh.checkRange(ErrOutputOutOfRange, "monitoring output", destination, func() int { return vh.monitorOutputs })
+}
+
+func (vh *Videohub) checkSerialPort(port int) error {
+	return vh.checkRange(ErrSerialPortOutOfRange, "serial port", port, func() int { return vh.serialPorts })
+}
+
+// checkRoute checks that destination and source exist and that destination
+// is not locked by another client.
+func (vh *Videohub) checkRoute(destination, source int) error {
+	if err := vh.checkOutput(destination); err != nil {
+		return err
+	}
+	if err := vh.checkInput(source); err != nil {
+		return err
+	}
+	return vh.checkUnlocked(destination)
+}
+
+// checkRange returns ErrNotReady before the device block has arrived, and
+// rangeErr if index is not below the count returned by count, which is called
+// with vh.mu held.
+func (vh *Videohub) checkRange(rangeErr error, kind string, index int, count func() int) error {
+	vh.mu.RLock()
+	ready, n := vh.deviceReady, count()
+	vh.mu.RUnlock()
+	if !ready {
+		return ErrNotReady
+	}
+	if index < 0 || index >= n {
+		return fmt.Errorf("%w: %s %d, device has %d", rangeErr, kind, index, n)
+	}
+	return nil
+}
+
+// portLimit is the number of entries a block may fill for a port type with
+// count ports: count once the device block has arrived, maxPorts before. The
+// caller must hold vh.mu.
+func (vh *Videohub) portLimit(count int) int {
+	if vh.deviceReady {
+		return count
+	}
+	return maxPorts
+}
+
+// parseCount parses a port count from the device block, clamped to
+// [0, maxPorts] so that a bogus value cannot exhaust memory.
+func parseCount(s string) int {
+	return min(max(parseInt(s), 0), maxPorts)
+}
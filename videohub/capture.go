@@ -0,0 +1,40 @@
+package videohub
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// WithWireCapture copies every byte received from the device to w, exactly
+// as it arrived, including the line endings and the ACK and NAK responses.
+// The capture is the raw protocol stream, so ParseDump can read it back and
+// it can be attached to bug reports as is. Unlike WithRecorder, nothing sent
+// to the device is captured and nothing is timestamped. Writes happen on the
+// reader goroutine, so w should not block.
+func WithWireCapture(w io.Writer) Option {
+	return func(vh *Videohub) {
+		vh.wireCapture = w
+	}
+}
+
+// ParseDump reads a raw protocol stream, such as one captured with
+// WithWireCapture, and returns the state it leaves the device in. Blocks are
+// framed and parsed as on a live connection; responses and an unterminated
+// final block are ignored.
+func ParseDump(r io.Reader) (Snapshot, error) {
+	vh := &Videohub{
+		historySize: DefaultHistorySize,
+		logger:      slog.New(discardHandler{}),
+		ready:       make(chan struct{}),
+	}
+	err := frameBlocks(r, nil, vh.responseProcessor, func(string) {})
+	if !errors.Is(err, io.EOF) {
+		return Snapshot{}, fmt.Errorf("videohub: failed to read dump: %w", err)
+	}
+	if _, _, ready := vh.Dimensions(); !ready {
+		return Snapshot{}, errors.New("videohub: dump has no VIDEOHUB DEVICE block")
+	}
+	return vh.Snapshot(), nil
+}
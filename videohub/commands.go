@@ -0,0 +1,253 @@
+package videohub
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// The Build* functions return the exact bytes a Videohub method writes to the
+// socket, including the blank line that terminates the block. They only check
+// what can be checked without a device (non-negative indexes, labels that are
+// valid UTF-8 and fit on one line); range checks against the device dimensions
+// and the character set given with WithLabelCharset happen in the methods that
+// send them.
+
+// BuildRouteCommand returns the VIDEO OUTPUT ROUTING block that routes source
+// to destination.
+func BuildRouteCommand(destination, source int) (string, error) {
+	return BuildBulkRouteCommand([][2]int{{destination, source}})
+}
+
+// BuildBulkRouteCommand returns a single VIDEO OUTPUT ROUTING block containing
+// every {destination, source} pair in routes.
+func BuildBulkRouteCommand(routes [][2]int) (string, error) {
+	if len(routes) == 0 {
+		return "", fmt.Errorf("videohub: no routes given")
+	}
+	lines := make([]string, 0, len(routes))
+	for _, route := range routes {
+		if err := checkIndex("output", route[0]); err != nil {
+			return "", err
+		}
+		if err := checkIndex("input", route[1]); err != nil {
+			return "", err
+		}
+		lines = append(lines, fmt.Sprintf("%d %d", route[0], route[1]))
+	}
+	return buildBlock("VIDEO OUTPUT ROUTING", lines), nil
+}
+
+// BuildMonitoringRouteCommand returns the MONITORING OUTPUT ROUTING block that
+// routes source to the monitoring output destination.
+func BuildMonitoringRouteCommand(destination, source int) (string, error) {
+	if err := checkIndex("monitoring output", destination); err != nil {
+		return "", err
+	}
+	if err := checkIndex("input", source); err != nil {
+		return "", err
+	}
+	return buildBlock("MONITORING OUTPUT ROUTING", []string{fmt.Sprintf("%d %d", destination, source)}), nil
+}
+
+// BuildSerialRouteCommand returns the SERIAL PORT ROUTING block that connects
+// serial port source to serial port destination.
+func BuildSerialRouteCommand(destination, source int) (string, error) {
+	if err := checkIndex("serial port", destination); err != nil {
+		return "", err
+	}
+	if err := checkIndex("serial port", source); err != nil {
+		return "", err
+	}
+	return buildBlock("SERIAL PORT ROUTING", []string{fmt.Sprintf("%d %d", destination, source)}), nil
+}
+
+// BuildSerialPortLabelCommand returns the SERIAL PORT LABELS block that sets
+// the label of serial port port.
+func BuildSerialPortLabelCommand(port int, label string) (string, error) {
+	return buildLabelCommand("SERIAL PORT LABELS", "serial port", port, label, nil)
+}
+
+// BuildSerialDirectionCommand returns the SERIAL PORT DIRECTIONS block that
+// sets the direction of serial port port.
+func BuildSerialDirectionCommand(port int, dir SerialDirection) (string, error) {
+	if err := checkIndex("serial port", port); err != nil {
+		return "", err
+	}
+	switch dir {
+	case SerialControl, SerialSlave, SerialAuto:
+	default:
+		return "", fmt.Errorf("videohub: unknown serial port direction %q", dir)
+	}
+	return buildBlock("SERIAL PORT DIRECTIONS", []string{fmt.Sprintf("%d %s", port, dir)}), nil
+}
+
+// BuildInputLabelCommand returns the INPUT LABELS block that sets the label of
+// source.
+func BuildInputLabelCommand(source int, label string) (string, error) {
+	return buildLabelCommand("INPUT LABELS", "input", source, label, nil)
+}
+
+// BuildOutputLabelCommand returns the OUTPUT LABELS block that sets the label
+// of destination.
+func BuildOutputLabelCommand(destination int, label string) (string, error) {
+	return buildLabelCommand("OUTPUT LABELS", "output", destination, label, nil)
+}
+
+// BuildBulkInputLabelCommand returns a single INPUT LABELS block setting every
+// label in labels, keyed by input, in index order.
+func BuildBulkInputLabelCommand(labels map[int]string) (string, error) {
+	return buildBulkLabelCommand("INPUT LABELS", "input", labels, nil)
+}
+
+// BuildBulkOutputLabelCommand returns a single OUTPUT LABELS block setting
+// every label in labels, keyed by output, in index order.
+func BuildBulkOutputLabelCommand(labels map[int]string) (string, error) {
+	return buildBulkLabelCommand("OUTPUT LABELS", "output", labels, nil)
+}
+
+// BuildLockOutputCommand returns the VIDEO OUTPUT LOCKS block that locks
+// destination for this client.
+func BuildLockOutputCommand(destination int) (string, error) {
+	return buildLockCommand(destination, LockOwned)
+}
+
+// BuildUnlockOutputCommand returns the VIDEO OUTPUT LOCKS block that releases
+// this client's lock on destination.
+func BuildUnlockOutputCommand(destination int) (string, error) {
+	return buildLockCommand(destination, LockUnlocked)
+}
+
+// BuildForceUnlockOutputCommand returns the VIDEO OUTPUT LOCKS block that
+// releases any client's lock on destination.
+func BuildForceUnlockOutputCommand(destination int) (string, error) {
+	return buildLockCommand(destination, LockForce)
+}
+
+func buildLockCommand(destination int, state string) (string, error) {
+	if err := checkIndex("output", destination); err != nil {
+		return "", err
+	}
+	return buildBlock("VIDEO OUTPUT LOCKS", []string{fmt.Sprintf("%d %s", destination, state)}), nil
+}
+
+// BuildMonitoringOutputLabelCommand returns the MONITORING OUTPUT LABELS block
+// that sets the label of the monitoring output destination.
+func BuildMonitoringOutputLabelCommand(destination int, label string) (string, error) {
+	return buildLabelCommand("MONITORING OUTPUT LABELS", "monitoring output", destination, label, nil)
+}
+
+// BuildTakeModeCommand returns the TAKE MODE block that turns Take Mode on or
+// off for destination.
+func BuildTakeModeCommand(destination int, enabled bool) (string, error) {
+	if err := checkIndex("output", destination); err != nil {
+		return "", err
+	}
+	return buildBlock("TAKE MODE", []string{fmt.Sprintf("%d %t", destination, enabled)}), nil
+}
+
+// BuildGlobalTakeModeCommand returns the CONFIGURATION block that turns Take
+// Mode on or off for the whole device.
+func BuildGlobalTakeModeCommand(enabled bool) string {
+	return buildBlock("CONFIGURATION", []string{fmt.Sprintf("Take Mode: %t", enabled)})
+}
+
+// buildLabelCommand builds a label block, also rejecting labels with
+// characters outside charset unless it is nil.
+func buildLabelCommand(header, kind string, index int, label string, charset LabelCharset) (string, error) {
+	if err := checkIndex(kind, index); err != nil {
+		return "", err
+	}
+	if err := checkLabel(label, charset); err != nil {
+		return "", err
+	}
+	return buildBlock(header, []string{fmt.Sprintf("%d %s", index, label)}), nil
+}
+
+func buildBulkLabelCommand(header, kind string, labels map[int]string, charset LabelCharset) (string, error) {
+	if len(labels) == 0 {
+		return "", fmt.Errorf("videohub: no labels given")
+	}
+	indexes := make([]int, 0, len(labels))
+	for i := range labels {
+		if err := checkIndex(kind, i); err != nil {
+			return "", err
+		}
+		if err := checkLabel(labels[i], charset); err != nil {
+			return "", err
+		}
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	lines := make([]string, len(indexes))
+	for n, i := range indexes {
+		lines[n] = fmt.Sprintf("%d %s", i, labels[i])
+	}
+	return buildBlock(header, lines), nil
+}
+
+// buildBlock frames lines as a protocol block terminated by a blank line.
+func buildBlock(header string, lines []string) string {
+	var b strings.Builder
+	b.WriteString(header)
+	b.WriteString(":\n")
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+func checkIndex(kind string, index int) error {
+	if index < 0 {
+		return fmt.Errorf("videohub: %s %d must not be negative", kind, index)
+	}
+	return nil
+}
+
+// ErrInvalidLabel is returned for labels that cannot be sent to the device.
+var ErrInvalidLabel = errors.New("videohub: invalid label")
+
+// LabelCharset reports whether labels may contain r. See WithLabelCharset.
+type LabelCharset func(r rune) bool
+
+// ASCIICharset allows printable ASCII, which every Videohub can display.
+func ASCIICharset(r rune) bool {
+	return r >= ' ' && r <= '~'
+}
+
+// Latin1Charset allows the printable characters of ISO 8859-1.
+func Latin1Charset(r rune) bool {
+	return ASCIICharset(r) || (r >= 0xA0 && r <= 0xFF)
+}
+
+// WithLabelCharset makes every label setter reject labels with characters
+// outside charset, such as characters the front panel of the device cannot
+// display, with ErrInvalidLabel. Without it any valid UTF-8 is sent.
+func WithLabelCharset(charset LabelCharset) Option {
+	return func(vh *Videohub) {
+		vh.labelCharset = charset
+	}
+}
+
+// checkLabel rejects labels that are not valid UTF-8, span several lines or,
+// unless charset is nil, contain characters outside charset.
+func checkLabel(label string, charset LabelCharset) error {
+	if !utf8.ValidString(label) {
+		return fmt.Errorf("%w: %q is not valid UTF-8", ErrInvalidLabel, label)
+	}
+	if strings.ContainsAny(label, "\r\n") {
+		return fmt.Errorf("%w: %q must not contain line breaks", ErrInvalidLabel, label)
+	}
+	if charset != nil {
+		for _, r := range label {
+			if !charset(r) {
+				return fmt.Errorf("%w: %q contains %q, which is outside the label character set", ErrInvalidLabel, label, r)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package videohub
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBuildCommands(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func() (string, error)
+		want  string
+	}{
+		{"route", func() (string, error) { return BuildRouteCommand(3, 12) },
+			"VIDEO OUTPUT ROUTING:\n3 12\n\n"},
+		{"bulk route", func() (string, error) { return BuildBulkRouteCommand([][2]int{{2, 0}, {0, 1}}) },
+			"VIDEO OUTPUT ROUTING:\n2 0\n0 1\n\n"},
+		{"monitoring route", func() (string, error) { return BuildMonitoringRouteCommand(0, 5) },
+			"MONITORING OUTPUT ROUTING:\n0 5\n\n"},
+		{"serial route", func() (string, error) { return BuildSerialRouteCommand(1, 2) },
+			"SERIAL PORT ROUTING:\n1 2\n\n"},
+		{"serial port label", func() (string, error) { return BuildSerialPortLabelCommand(1, "VTR 1") },
+			"SERIAL PORT LABELS:\n1 VTR 1\n\n"},
+		{"serial direction", func() (string, error) { return BuildSerialDirectionCommand(2, SerialSlave) },
+			"SERIAL PORT DIRECTIONS:\n2 slave\n\n"},
+		{"input label", func() (string, error) { return BuildInputLabelCommand(0, "Caméra 1") },
+			"INPUT LABELS:\n0 Caméra 1\n\n"},
+		{"empty input label", func() (string, error) { return BuildInputLabelCommand(4, "") },
+			"INPUT LABELS:\n4 \n\n"},
+		{"output label", func() (string, error) { return BuildOutputLabelCommand(7, "Program") },
+			"OUTPUT LABELS:\n7 Program\n\n"},
+		{"bulk input labels", func() (string, error) { return BuildBulkInputLabelCommand(map[int]string{3: "C", 0: "A", 1: "B"}) },
+			"INPUT LABELS:\n0 A\n1 B\n3 C\n\n"},
+		{"bulk output labels", func() (string, error) { return BuildBulkOutputLabelCommand(map[int]string{1: "Y", 0: "X"}) },
+			"OUTPUT LABELS:\n0 X\n1 Y\n\n"},
+		{"lock", func() (string, error) { return BuildLockOutputCommand(4) },
+			"VIDEO OUTPUT LOCKS:\n4 O\n\n"},
+		{"unlock", func() (string, error) { return BuildUnlockOutputCommand(4) },
+			"VIDEO OUTPUT LOCKS:\n4 U\n\n"},
+		{"force unlock", func() (string, error) { return BuildForceUnlockOutputCommand(4) },
+			"VIDEO OUTPUT LOCKS:\n4 F\n\n"},
+		{"monitoring output label", func() (string, error) { return BuildMonitoringOutputLabelCommand(1, "Desk") },
+			"MONITORING OUTPUT LABELS:\n1 Desk\n\n"},
+		{"take mode", func() (string, error) { return BuildTakeModeCommand(2, true) },
+			"TAKE MODE:\n2 true\n\n"},
+		{"global take mode", func() (string, error) { return BuildGlobalTakeModeCommand(false), nil },
+			"CONFIGURATION:\nTake Mode: false\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildCommandErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		build   func() (string, error)
+		wantErr string
+		is      error // Sentinel the error must wrap, if any
+	}{
+		{"route to negative output", func() (string, error) { return BuildRouteCommand(-1, 0) }, "output -1 must not be negative", nil},
+		{"route from negative input", func() (string, error) { return BuildRouteCommand(0, -2) }, "input -2 must not be negative", nil},
+		{"bulk route negative", func() (string, error) { return BuildBulkRouteCommand([][2]int{{0, 0}, {-1, 0}}) }, "output -1 must not be negative", nil},
+		{"empty bulk route", func() (string, error) { return BuildBulkRouteCommand(nil) }, "no routes given", nil},
+		{"monitoring route negative", func() (string, error) { return BuildMonitoringRouteCommand(-1, 0) }, "monitoring output -1 must not be negative", nil},
+		{"serial route negative", func() (string, error) { return BuildSerialRouteCommand(0, -1) }, "serial port -1 must not be negative", nil},
+		{"serial label negative", func() (string, error) { return BuildSerialPortLabelCommand(-1, "VTR") }, "serial port -1 must not be negative", nil},
+		{"serial direction negative", func() (string, error) { return BuildSerialDirectionCommand(-1, SerialAuto) }, "serial port -1 must not be negative", nil},
+		{"unknown serial direction", func() (string, error) { return BuildSerialDirectionCommand(0, "sideways") }, `unknown serial port direction "sideways"`, nil},
+		{"input label negative", func() (string, error) { return BuildInputLabelCommand(-1, "A") }, "input -1 must not be negative", nil},
+		{"input label invalid UTF-8", func() (string, error) { return BuildInputLabelCommand(0, "A\xff") }, "not valid UTF-8", ErrInvalidLabel},
+		{"input label LF", func() (string, error) { return BuildInputLabelCommand(0, "A\nB") }, "line breaks", ErrInvalidLabel},
+		{"output label CR", func() (string, error) { return BuildOutputLabelCommand(0, "A\rB") }, "line breaks", ErrInvalidLabel},
+		{"output label negative", func() (string, error) { return BuildOutputLabelCommand(-3, "A") }, "output -3 must not be negative", nil},
+		{"empty bulk input labels", func() (string, error) { return BuildBulkInputLabelCommand(nil) }, "no labels given", nil},
+		{"empty bulk output labels", func() (string, error) { return BuildBulkOutputLabelCommand(map[int]string{}) }, "no labels given", nil},
+		{"bulk labels negative", func() (string, error) { return BuildBulkInputLabelCommand(map[int]string{-1: "A"}) }, "input -1 must not be negative", nil},
+		{"bulk labels CRLF", func() (string, error) { return BuildBulkOutputLabelCommand(map[int]string{0: "A", 1: "B\r\n"}) }, "line breaks", ErrInvalidLabel},
+		{"bulk labels invalid UTF-8", func() (string, error) { return BuildBulkOutputLabelCommand(map[int]string{0: "\xc3"}) }, "not valid UTF-8", ErrInvalidLabel},
+		{"lock negative", func() (string, error) { return BuildLockOutputCommand(-1) }, "output -1 must not be negative", nil},
+		{"unlock negative", func() (string, error) { return BuildUnlockOutputCommand(-1) }, "output -1 must not be negative", nil},
+		{"force unlock negative", func() (string, error) { return BuildForceUnlockOutputCommand(-1) }, "output -1 must not be negative", nil},
+		{"monitoring label LF", func() (string, error) { return BuildMonitoringOutputLabelCommand(0, "\n") }, "line breaks", ErrInvalidLabel},
+		{"take mode negative", func() (string, error) { return BuildTakeModeCommand(-1, true) }, "output -1 must not be negative", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.build()
+			if err == nil {
+				t.Fatalf("got %q, want an error", got)
+			}
+			if got != "" {
+				t.Errorf("got %q along with the error", got)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error %q does not mention %q", err, tt.wantErr)
+			}
+			if tt.is != nil && !errors.Is(err, tt.is) {
+				t.Errorf("error %q does not wrap %v", err, tt.is)
+			}
+		})
+	}
+}
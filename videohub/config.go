@@ -0,0 +1,157 @@
+package videohub
+
+import (
+	"fmt"
+	"sort"
+)
+
+// BulkInputLabels sets every label in labels, keyed by input, with a single
+// INPUT LABELS block.
+func (vh *Videohub) BulkInputLabels(labels map[int]string) error {
+	for i := range labels {
+		if err := vh.checkInput(i); err != nil {
+			return err
+		}
+	}
+	return vh.sendBuilt(buildBulkLabelCommand(string(BlockInputLabels), "input", labels, vh.labelCharset))
+}
+
+// BulkOutputLabels sets every label in labels, keyed by output, with a single
+// OUTPUT LABELS block.
+func (vh *Videohub) BulkOutputLabels(labels map[int]string) error {
+	for i := range labels {
+		if err := vh.checkOutput(i); err != nil {
+			return err
+		}
+	}
+	return vh.sendBuilt(buildBulkLabelCommand(string(BlockOutputLabels), "output", labels, vh.labelCharset))
+}
+
+// Config is the desired state of some or all ports, as pushed by ApplyConfig.
+// Ports that are not mentioned are left alone.
+type Config struct {
+	InputLabels  map[int]string `json:"inputLabels,omitempty"`
+	OutputLabels map[int]string `json:"outputLabels,omitempty"`
+	Routing      map[int]int    `json:"routing,omitempty"` // Input for each output
+}
+
+// LabelDiff is one label changed by ApplyConfig.
+type LabelDiff struct {
+	Index int    `json:"index"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// ConfigReport describes what ApplyConfig changed.
+type ConfigReport struct {
+	InputLabels  []LabelDiff `json:"inputLabels"`
+	OutputLabels []LabelDiff `json:"outputLabels"`
+	Routes       []RouteDiff `json:"routes"`
+	Unchanged    int         `json:"unchanged"` // Entries of the config already in effect
+}
+
+// Changed returns the number of entries ApplyConfig sent to the device.
+func (r ConfigReport) Changed() int {
+	return len(r.InputLabels) + len(r.OutputLabels) + len(r.Routes)
+}
+
+// ApplyConfig brings the device to cfg. Only entries that differ from the
+// current state are sent, as at most one block each for input labels, output
+// labels and routing, in a single write. The whole config is checked first;
+// if any entry names a port the device does not have, has an invalid label or
+// routes a locked output, nothing is sent. The report lists what was sent and
+// is empty if the write fails.
+func (vh *Videohub) ApplyConfig(cfg Config) (ConfigReport, error) {
+	var report ConfigReport
+	for i, label := range cfg.InputLabels {
+		if err := vh.checkInput(i); err != nil {
+			return ConfigReport{}, err
+		}
+		if err := checkLabel(label, vh.labelCharset); err != nil {
+			return ConfigReport{}, err
+		}
+	}
+	for o, label := range cfg.OutputLabels {
+		if err := vh.checkOutput(o); err != nil {
+			return ConfigReport{}, err
+		}
+		if err := checkLabel(label, vh.labelCharset); err != nil {
+			return ConfigReport{}, err
+		}
+	}
+	// Dimensions may change while the config is applied; pad the copies so
+	// that indexes checked above stay valid.
+	current := growRouting(vh.Routing(), maxPorts)
+	for o, i := range cfg.Routing {
+		if err := vh.checkOutput(o); err != nil {
+			return ConfigReport{}, err
+		}
+		if err := vh.checkInput(i); err != nil {
+			return ConfigReport{}, err
+		}
+		if current[o] != i {
+			if err := vh.checkUnlocked(o); err != nil {
+				return ConfigReport{}, err
+			}
+		}
+	}
+
+	var command string
+	labels := func(want map[int]string, have []string, diffs *[]LabelDiff, build func(map[int]string) (string, error)) error {
+		changed := make(map[int]string)
+		for _, i := range sortedKeys(want) {
+			if want[i] == have[i] {
+				report.Unchanged++
+				continue
+			}
+			changed[i] = want[i]
+			*diffs = append(*diffs, LabelDiff{Index: i, From: have[i], To: want[i]})
+		}
+		if len(changed) == 0 {
+			return nil
+		}
+		block, err := build(changed)
+		command += block
+		return err
+	}
+	if err := labels(cfg.InputLabels, growLabels(vh.InputLabels(), maxPorts), &report.InputLabels, BuildBulkInputLabelCommand); err != nil {
+		return ConfigReport{}, err
+	}
+	if err := labels(cfg.OutputLabels, growLabels(vh.OutputLabels(), maxPorts), &report.OutputLabels, BuildBulkOutputLabelCommand); err != nil {
+		return ConfigReport{}, err
+	}
+
+	var routes [][2]int
+	for _, o := range sortedKeys(cfg.Routing) {
+		if current[o] == cfg.Routing[o] {
+			report.Unchanged++
+			continue
+		}
+		routes = append(routes, [2]int{o, cfg.Routing[o]})
+		report.Routes = append(report.Routes, RouteDiff{Destination: o, From: current[o], To: cfg.Routing[o]})
+	}
+	if len(routes) > 0 {
+		block, err := BuildBulkRouteCommand(routes)
+		if err != nil {
+			return ConfigReport{}, err
+		}
+		command += block
+	}
+
+	if command == "" {
+		return report, nil
+	}
+	if err := vh.sendSync(command); err != nil {
+		return ConfigReport{Unchanged: report.Unchanged}, fmt.Errorf("videohub: config not applied: %w", err)
+	}
+	return report, nil
+}
+
+func sortedKeys[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
@@ -0,0 +1,292 @@
+package videohub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNAK is returned when the device rejects a command.
+	ErrNAK = errors.New("videohub: command rejected by device (NAK)")
+	// ErrTimeout is returned when the device does not answer a command in time.
+	ErrTimeout = errors.New("videohub: timed out waiting for response")
+	// ErrConnectionLost is returned for commands sent while the connection
+	// is down, or whose response was lost because the connection dropped.
+	ErrConnectionLost = errors.New("videohub: connection lost before response")
+)
+
+// pendingCommand tracks a write awaiting ACK/NAK. The device answers every
+// block separately and in order, so a write of several blocks is complete once
+// all of them have been answered. A command whose caller gave up waiting stays
+// queued, so that a late answer is matched to it and not to the next command.
+type pendingCommand struct {
+	blocks int
+	err    error
+	result chan error // Buffered; nil for fire-and-forget writes
+}
+
+// RouteSync is the same as Route.
+//
+// Deprecated: Route now waits for the device to acknowledge the command.
+func (vh *Videohub) RouteSync(destination, source int) error {
+	return vh.Route(destination, source)
+}
+
+// RouteContext routes source to destination and waits, until ctx is done, for
+// the device to acknowledge it.
+func (vh *Videohub) RouteContext(ctx context.Context, destination, source int) error {
+	if err := vh.checkRoute(destination, source); err != nil {
+		return err
+	}
+	command, err := BuildRouteCommand(destination, source)
+	if err != nil {
+		return err
+	}
+	return vh.sendContext(ctx, command)
+}
+
+// BulkRouteContext is BulkRoute, waiting until ctx is done for the device to
+// acknowledge the routes.
+func (vh *Videohub) BulkRouteContext(ctx context.Context, routes [][2]int) error {
+	for _, route := range routes {
+		if err := vh.checkRoute(route[0], route[1]); err != nil {
+			return err
+		}
+	}
+	command, err := BuildBulkRouteCommand(routes)
+	if err != nil {
+		return err
+	}
+	return vh.sendContext(ctx, command)
+}
+
+// InputLabelContext is InputLabel, waiting until ctx is done for the device to
+// acknowledge the label.
+func (vh *Videohub) InputLabelContext(ctx context.Context, source int, label string) error {
+	if err := vh.checkInput(source); err != nil {
+		return err
+	}
+	command, err := buildLabelCommand(string(BlockInputLabels), "input", source, label, vh.labelCharset)
+	if err != nil {
+		return err
+	}
+	return vh.sendContext(ctx, command)
+}
+
+// OutputLabelContext is OutputLabel, waiting until ctx is done for the device
+// to acknowledge the label.
+func (vh *Videohub) OutputLabelContext(ctx context.Context, destination int, label string) error {
+	if err := vh.checkOutput(destination); err != nil {
+		return err
+	}
+	command, err := buildLabelCommand(string(BlockOutputLabels), "output", destination, label, vh.labelCharset)
+	if err != nil {
+		return err
+	}
+	return vh.sendContext(ctx, command)
+}
+
+// RouteAsync routes source to destination without waiting for the device. The
+// returned channel receives nil once the device has acknowledged the route,
+// ErrNAK if it rejected it or ErrTimeout after the command timeout, and is
+// not closed. Commands are written in the order of the calls, so many of them
+// can be in flight at once and awaited together:
+//
+//	results := make([]<-chan error, len(routes))
+//	for i, r := range routes {
+//		results[i] = vh.RouteAsync(r[0], r[1])
+//	}
+//	for _, result := range results {
+//		if err := <-result; err != nil {
+//			...
+//		}
+//	}
+//
+// Errors found before sending, such as ErrOutputLocked, are delivered on the
+// channel as well.
+func (vh *Videohub) RouteAsync(destination, source int) <-chan error {
+	if err := vh.checkRoute(destination, source); err != nil {
+		return asyncError(err)
+	}
+	command, err := BuildRouteCommand(destination, source)
+	if err != nil {
+		return asyncError(err)
+	}
+	return vh.sendAsync(command)
+}
+
+// InputLabelAsync is InputLabel without waiting for the device, as with
+// RouteAsync.
+func (vh *Videohub) InputLabelAsync(source int, label string) <-chan error {
+	if err := vh.checkInput(source); err != nil {
+		return asyncError(err)
+	}
+	command, err := buildLabelCommand(string(BlockInputLabels), "input", source, label, vh.labelCharset)
+	if err != nil {
+		return asyncError(err)
+	}
+	return vh.sendAsync(command)
+}
+
+// OutputLabelAsync is OutputLabel without waiting for the device, as with
+// RouteAsync.
+func (vh *Videohub) OutputLabelAsync(destination int, label string) <-chan error {
+	if err := vh.checkOutput(destination); err != nil {
+		return asyncError(err)
+	}
+	command, err := buildLabelCommand(string(BlockOutputLabels), "output", destination, label, vh.labelCharset)
+	if err != nil {
+		return asyncError(err)
+	}
+	return vh.sendAsync(command)
+}
+
+// asyncError returns a result channel already holding err.
+func asyncError(err error) <-chan error {
+	result := make(chan error, 1)
+	result <- err
+	return result
+}
+
+// sendSync writes command and waits up to the command timeout for every block
+// in it to be answered.
+func (vh *Videohub) sendSync(command string) error {
+	ctx, cancel := vh.commandContext()
+	defer cancel()
+	return vh.sendContext(ctx, command)
+}
+
+// commandContext bounds a command by the configured command timeout.
+func (vh *Videohub) commandContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), vh.commandTimeout)
+}
+
+// sendContext writes command and waits until ctx is done for every block in it
+// to be answered. An expired deadline is reported as ErrTimeout.
+func (vh *Videohub) sendContext(ctx context.Context, command string) error {
+	result := make(chan error, 1)
+	start := time.Now()
+	if err := vh.write(ctx, command, result); err != nil {
+		return err
+	}
+	return vh.await(ctx, result, start)
+}
+
+// sendAsync writes command and returns a channel that receives the result once
+// every block in it has been answered or the command timeout has expired. The
+// write itself happens before sendAsync returns, so commands are sent in the
+// order of the calls.
+func (vh *Videohub) sendAsync(command string) <-chan error {
+	ctx, cancel := vh.commandContext()
+	result := make(chan error, 1)
+	start := time.Now()
+	if err := vh.write(ctx, command, result); err != nil {
+		cancel()
+		return asyncError(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		defer cancel()
+		done <- vh.await(ctx, result, start)
+	}()
+	return done
+}
+
+// await waits until ctx is done for the result of a command written at start.
+func (vh *Videohub) await(ctx context.Context, result <-chan error, start time.Time) error {
+	select {
+	case err := <-result:
+		if err == nil || errors.Is(err, ErrNAK) {
+			vh.metrics.observeCommand(time.Since(start))
+		}
+		return err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			vh.metrics.timeouts.Add(1)
+			return ErrTimeout
+		}
+		return ctx.Err()
+	}
+}
+
+// write sends command and queues it for ACK/NAK correlation. Queueing and
+// writing happen under one lock so the queue order matches the wire order. The
+// write is bounded by the write timeout and by the deadline of ctx.
+func (vh *Videohub) write(ctx context.Context, command string, result chan error) error {
+	vh.logger.Debug("Sending command", "command", strings.ReplaceAll(strings.TrimSuffix(command, "\n\n"), "\n", "-"))
+	if vh.closed() {
+		return ErrClosed
+	}
+	switch vh.connectionState() {
+	case StateConnected:
+	case StateFailed:
+		return ErrReconnectFailed
+	default:
+		return ErrConnectionLost
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	vh.sendMu.Lock()
+	defer vh.sendMu.Unlock()
+	var deadline time.Time
+	if vh.writeTimeout > 0 {
+		deadline = time.Now().Add(vh.writeTimeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+	conn := vh.currentConn()
+	if err := conn.SetWriteDeadline(deadline); err != nil {
+		return fmt.Errorf("videohub: failed to set write deadline: %w", err)
+	}
+	vh.pendingMu.Lock()
+	vh.pending = append(vh.pending, &pendingCommand{blocks: strings.Count(command, "\n\n"), result: result})
+	vh.pendingMu.Unlock()
+	vh.recorder.record('>', strings.Split(strings.TrimSuffix(command, "\n"), "\n")...)
+	if _, err := conn.Write([]byte(command)); err != nil {
+		// Closing the socket makes the reader notice and reconnect, which
+		// also fails everything still pending.
+		conn.Close()
+		return fmt.Errorf("%w: failed to send command: %v", ErrConnectionLost, err)
+	}
+	return nil
+}
+
+// resolvePending applies one ACK (err == nil) or NAK to the oldest pending
+// command.
+func (vh *Videohub) resolvePending(err error) {
+	vh.pendingMu.Lock()
+	defer vh.pendingMu.Unlock()
+	if len(vh.pending) == 0 {
+		vh.logger.Warn("Received unexpected response with no command pending")
+		return
+	}
+	p := vh.pending[0]
+	p.blocks--
+	if err != nil && p.err == nil {
+		p.err = err
+	}
+	if p.blocks > 0 {
+		return
+	}
+	vh.pending = vh.pending[1:]
+	if p.result != nil {
+		p.result <- p.err
+	}
+}
+
+// failPending fails every command still waiting for a response.
+func (vh *Videohub) failPending(err error) {
+	vh.pendingMu.Lock()
+	defer vh.pendingMu.Unlock()
+	for _, p := range vh.pending {
+		if p.result != nil {
+			p.result <- err
+		}
+	}
+	vh.pending = nil
+}
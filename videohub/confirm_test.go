@@ -0,0 +1,238 @@
+package videohub
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readCommands plays the device side of conn after the dump: it returns a
+// channel receiving every block the Videohub writes, blank line included.
+func readCommands(conn net.Conn) <-chan string {
+	blocks := make(chan string, 16)
+	go func() {
+		defer close(blocks)
+		reader := bufio.NewReader(conn)
+		var block strings.Builder
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			block.WriteString(line)
+			if line == "\n" {
+				blocks <- block.String()
+				block.Reset()
+			}
+		}
+	}()
+	return blocks
+}
+
+// nextCommand returns the next block read by readCommands.
+func nextCommand(t *testing.T, blocks <-chan string) string {
+	t.Helper()
+	select {
+	case block := <-blocks:
+		return block
+	case <-time.After(time.Second):
+		t.Fatal("no command sent")
+		return ""
+	}
+}
+
+// readyHub returns a Videohub over a pipe that has received the dump of a 4x4
+// device, and the channel of commands it sends.
+func readyHub(t *testing.T, opts ...Option) (*Videohub, net.Conn, <-chan string) {
+	t.Helper()
+	vh, device := pipeHub(t, opts...)
+	writeChunks(device, testDump{4, 4}.text("\n"), 4096)
+	waitReady(t, vh)
+	return vh, device, readCommands(device)
+}
+
+func TestRouteAsync(t *testing.T) {
+	vh, device, commands := readyHub(t)
+
+	first := vh.RouteAsync(1, 2)
+	second := vh.RouteAsync(3, 0)
+	if got, want := nextCommand(t, commands), "VIDEO OUTPUT ROUTING:\n1 2\n\n"; got != want {
+		t.Errorf("first command %q, want %q", got, want)
+	}
+	if got, want := nextCommand(t, commands), "VIDEO OUTPUT ROUTING:\n3 0\n\n"; got != want {
+		t.Errorf("second command %q, want %q", got, want)
+	}
+	device.Write([]byte("NAK\nACK\n"))
+	if err := <-first; !errors.Is(err, ErrNAK) {
+		t.Errorf("first result %v, want ErrNAK", err)
+	}
+	if err := <-second; err != nil {
+		t.Errorf("second result %v, want nil", err)
+	}
+
+	if err := <-vh.RouteAsync(4, 0); !errors.Is(err, ErrOutputOutOfRange) {
+		t.Errorf("RouteAsync to a missing output: %v, want ErrOutputOutOfRange", err)
+	}
+}
+
+func TestLabelAsync(t *testing.T) {
+	vh, device, commands := readyHub(t)
+
+	input := vh.InputLabelAsync(0, "Camera A")
+	output := vh.OutputLabelAsync(2, "Program")
+	if got, want := nextCommand(t, commands), "INPUT LABELS:\n0 Camera A\n\n"; got != want {
+		t.Errorf("input label command %q, want %q", got, want)
+	}
+	if got, want := nextCommand(t, commands), "OUTPUT LABELS:\n2 Program\n\n"; got != want {
+		t.Errorf("output label command %q, want %q", got, want)
+	}
+	device.Write([]byte("ACK\nNAK\n"))
+	if err := <-input; err != nil {
+		t.Errorf("InputLabelAsync result %v, want nil", err)
+	}
+	if err := <-output; !errors.Is(err, ErrNAK) {
+		t.Errorf("OutputLabelAsync result %v, want ErrNAK", err)
+	}
+}
+
+func TestRouteAsyncTimeout(t *testing.T) {
+	vh, _, commands := readyHub(t, WithCommandTimeout(20*time.Millisecond))
+	result := vh.RouteAsync(0, 1)
+	nextCommand(t, commands)
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrTimeout) {
+			t.Errorf("result %v, want ErrTimeout", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("unanswered RouteAsync never timed out")
+	}
+}
+
+// sendInBackground runs sendContext for command with a one second limit and
+// returns the channel receiving its result.
+func sendInBackground(vh *Videohub, command string) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		result <- vh.sendContext(ctx, command)
+	}()
+	return result
+}
+
+// assertPending fails the test if result is delivered within a short while.
+func assertPending(t *testing.T, result <-chan error, what string) {
+	t.Helper()
+	select {
+	case err := <-result:
+		t.Fatalf("%s resolved early: %v", what, err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// assertResult waits for result and checks it against want (nil for
+// success).
+func assertResult(t *testing.T, result <-chan error, what string, want error) {
+	t.Helper()
+	select {
+	case err := <-result:
+		if want == nil && err != nil || want != nil && !errors.Is(err, want) {
+			t.Errorf("%s: %v, want %v", what, err, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("%s never resolved", what)
+	}
+}
+
+const twoBlocks = "INPUT LABELS:\n0 A\n\nOUTPUT LABELS:\n0 B\n\n"
+
+func TestMultiBlockWriteNeedsEveryAnswer(t *testing.T) {
+	vh, device, commands := readyHub(t)
+	result := sendInBackground(vh, twoBlocks)
+	nextCommand(t, commands)
+	nextCommand(t, commands)
+	device.Write([]byte("ACK\n"))
+	assertPending(t, result, "two-block write after one ACK")
+	device.Write([]byte("ACK\n"))
+	assertResult(t, result, "two-block write after two ACKs", nil)
+}
+
+func TestMultiBlockWriteNAKOnSecondBlock(t *testing.T) {
+	vh, device, commands := readyHub(t)
+	result := sendInBackground(vh, twoBlocks)
+	nextCommand(t, commands)
+	nextCommand(t, commands)
+	device.Write([]byte("ACK\nNAK\n"))
+	assertResult(t, result, "two-block write answered ACK, NAK", ErrNAK)
+
+	// The queue is empty again: the next command gets its own answer.
+	next := sendInBackground(vh, "VIDEO OUTPUT ROUTING:\n0 1\n\n")
+	nextCommand(t, commands)
+	device.Write([]byte("ACK\n"))
+	assertResult(t, next, "next command", nil)
+}
+
+func TestLateAnswerAfterTimeout(t *testing.T) {
+	vh, device, commands := readyHub(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := vh.RouteContext(ctx, 0, 1); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("unanswered RouteContext = %v, want ErrTimeout", err)
+	}
+	nextCommand(t, commands)
+
+	next := sendInBackground(vh, "VIDEO OUTPUT ROUTING:\n1 2\n\n")
+	nextCommand(t, commands)
+	// The late ACK belongs to the timed-out route.
+	device.Write([]byte("ACK\n"))
+	assertPending(t, next, "command after a timed-out one")
+	device.Write([]byte("NAK\n"))
+	assertResult(t, next, "command after a timed-out one", ErrNAK)
+}
+
+func TestPendingFailedOnDisconnect(t *testing.T) {
+	// Reconnect attempts block until Close, so the Videohub stays in
+	// StateReconnecting.
+	vh, device, commands := readyHub(t, WithDialer(blockingDial))
+	result := vh.RouteAsync(2, 3)
+	nextCommand(t, commands)
+	device.Close()
+	assertResult(t, result, "route pending on disconnect", ErrConnectionLost)
+
+	eventually(t, "StateReconnecting", func() bool { return vh.connectionState() == StateReconnecting })
+	if err := vh.Route(1, 1); !errors.Is(err, ErrConnectionLost) {
+		t.Errorf("Route while reconnecting = %v, want ErrConnectionLost", err)
+	}
+}
+
+func TestMonitorRoute(t *testing.T) {
+	vh, device := pipeHub(t)
+	dump := strings.Replace(testDump{4, 4}.text("\n"), "Video monitoring outputs: 0", "Video monitoring outputs: 2", 1)
+	writeChunks(device, dump, 4096)
+	waitReady(t, vh)
+	commands := readCommands(device)
+
+	result := make(chan error, 1)
+	go func() { result <- vh.MonitorRoute(1, 3) }()
+	if got, want := nextCommand(t, commands), "MONITORING OUTPUT ROUTING:\n1 3\n\n"; got != want {
+		t.Errorf("MonitorRoute sent %q, want %q", got, want)
+	}
+	device.Write([]byte("ACK\n"))
+	assertResult(t, result, "MonitorRoute", nil)
+
+	go func() { result <- vh.MonitorOutputLabel(0, "Desk") }()
+	if got, want := nextCommand(t, commands), "MONITORING OUTPUT LABELS:\n0 Desk\n\n"; got != want {
+		t.Errorf("MonitorOutputLabel sent %q, want %q", got, want)
+	}
+	device.Write([]byte("ACK\n"))
+	assertResult(t, result, "MonitorOutputLabel", nil)
+
+	if err := vh.MonitorRoute(2, 0); !errors.Is(err, ErrOutputOutOfRange) {
+		t.Errorf("MonitorRoute to a missing monitoring output: %v, want ErrOutputOutOfRange", err)
+	}
+}
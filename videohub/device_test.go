@@ -0,0 +1,547 @@
+package videohub_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/StechLabs/pydeohub/videohub"
+	"github.com/StechLabs/pydeohub/videohub/simulator"
+)
+
+// startSimulator serves cfg on addr until the test ends.
+func startSimulator(t *testing.T, addr string, cfg simulator.Config) *simulator.Server {
+	t.Helper()
+	sim := simulator.New(cfg)
+	if err := sim.Start(addr); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sim.Close() })
+	return sim
+}
+
+// connect returns a Videohub connected to sim and ready.
+func connect(t *testing.T, sim *simulator.Server, opts ...videohub.Option) *videohub.Videohub {
+	t.Helper()
+	host, port, _ := net.SplitHostPort(sim.Addr().String())
+	p, _ := strconv.Atoi(port)
+	vh, err := videohub.NewVideohubWithOptions(host, append([]videohub.Option{videohub.WithPort(p), videohub.WithLogger(nil)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { vh.Close() })
+	if err := vh.WaitForReady(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	return vh
+}
+
+func TestDifferentDeviceAfterReconnect(t *testing.T) {
+	first := startSimulator(t, "127.0.0.1:0", simulator.Config{UniqueID: "7C2E0D00000A", Inputs: 4, Outputs: 4})
+	addr := first.Addr().String()
+	vh := connect(t, first)
+	first.Route(1, 3)
+	first.SetInputLabel(0, "Old camera")
+	events, cancel := vh.Subscribe()
+	defer cancel()
+	handled := make(chan [2]string, 1)
+	vh.OnDeviceChange(func(oldID, newID string) { handled <- [2]string{oldID, newID} })
+
+	first.Close()
+	second := startSimulator(t, addr, simulator.Config{UniqueID: "7C2E0D00000B", Inputs: 8, Outputs: 6})
+
+	select {
+	case ids := <-handled:
+		if want := [2]string{"7C2E0D00000A", "7C2E0D00000B"}; ids != want {
+			t.Errorf("OnDeviceChange(%q, %q), want %q", ids[0], ids[1], want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnDeviceChange not called after reconnecting to another device")
+	}
+	var got *videohub.DeviceChange
+	for got == nil {
+		select {
+		case ev := <-events:
+			if c, ok := ev.(videohub.DeviceChange); ok {
+				got = &c
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no DeviceChange event")
+		}
+	}
+	if want := (videohub.DeviceChange{OldID: "7C2E0D00000A", NewID: "7C2E0D00000B"}); *got != want {
+		t.Errorf("event %+v, want %+v", *got, want)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !reflect.DeepEqual(vh.Routing(), second.Routing()) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if inputs, outputs, _ := vh.Dimensions(); inputs != 8 || outputs != 6 {
+		t.Errorf("Dimensions() = %dx%d, want 8x6", inputs, outputs)
+	}
+	if got, want := vh.UniqueID(), "7C2E0D00000B"; got != want {
+		t.Errorf("UniqueID() = %q, want %q", got, want)
+	}
+	if got, want := vh.InputLabels(), second.InputLabels(); !reflect.DeepEqual(got, want) {
+		t.Errorf("InputLabels() = %q, want %q", got, want)
+	}
+	if got, want := vh.OutputLabels(), second.OutputLabels(); !reflect.DeepEqual(got, want) {
+		t.Errorf("OutputLabels() = %q, want %q", got, want)
+	}
+	if got, want := vh.Routing(), second.Routing(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Routing() = %v, want %v", got, want)
+	}
+}
+
+func TestForceUnlock(t *testing.T) {
+	sim := startSimulator(t, "127.0.0.1:0", simulator.Config{Inputs: 4, Outputs: 4})
+	holder := connect(t, sim)
+	if err := holder.LockOutput(1); err != nil {
+		t.Fatal(err)
+	}
+
+	shared := connect(t, sim)
+	if err := shared.ForceUnlockOutput(1); !errors.Is(err, videohub.ErrForceUnlockDisabled) {
+		t.Errorf("ForceUnlockOutput without WithAllowForceUnlock: %v, want ErrForceUnlockDisabled", err)
+	}
+
+	admin := connect(t, sim, videohub.WithAllowForceUnlock(true))
+	deadline := time.Now().Add(time.Second)
+	for admin.LockState(1) != videohub.LockLocked && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	events, cancel := admin.Subscribe()
+	defer cancel()
+	if err := admin.ForceUnlockOutput(1); err != nil {
+		t.Fatalf("ForceUnlockOutput: %v", err)
+	}
+	for {
+		select {
+		case ev := <-events:
+			c, ok := ev.(videohub.ForcedUnlock)
+			if !ok {
+				continue
+			}
+			if c.Destination != 1 || c.Previous != videohub.LockLocked {
+				t.Errorf("event %+v, want output 1 previously %q", c, videohub.LockLocked)
+			}
+			return
+		case <-time.After(time.Second):
+			t.Fatal("no ForcedUnlock event")
+		}
+	}
+}
+
+func TestRestoreLocks(t *testing.T) {
+	sim := startSimulator(t, "127.0.0.1:0", simulator.Config{Inputs: 4, Outputs: 4})
+	vh := connect(t, sim)
+	if err := vh.LockOutput(2); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for vh.LockState(2) != videohub.LockOwned && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	snap := vh.Snapshot()
+	if got, want := snap.Locks, []string{"none", "none", "owned", "none"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Snapshot().Locks = %q, want %q", got, want)
+	}
+
+	if err := vh.UnlockOutput(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := vh.LockOutput(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := vh.Restore(snap); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	want := []string{videohub.LockUnlocked, videohub.LockUnlocked, videohub.LockOwned, videohub.LockUnlocked}
+	deadline = time.Now().Add(time.Second)
+	for !reflect.DeepEqual(vh.Locks(), want) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := vh.Locks(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Locks() after Restore = %q, want %q", got, want)
+	}
+}
+
+func TestRelockOnReconnect(t *testing.T) {
+	sim := startSimulator(t, "127.0.0.1:0", simulator.Config{Inputs: 4, Outputs: 4})
+	vh := connect(t, sim, videohub.WithResilientMode())
+	for _, o := range []int{1, 3} {
+		if err := vh.LockOutput(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for (vh.LockState(1) != videohub.LockOwned || vh.LockState(3) != videohub.LockOwned) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	events, cancel := vh.Subscribe()
+	defer cancel()
+
+	sim.DisconnectAll()
+	// The dump after the reconnect shows the locks released, then the
+	// Videohub takes them again.
+	released, relocked := map[int]bool{}, map[int]bool{}
+	timeout := time.After(5 * time.Second)
+	for !relocked[1] || !relocked[3] {
+		select {
+		case ev := <-events:
+			c, ok := ev.(videohub.LockChange)
+			if !ok {
+				continue
+			}
+			switch c.State {
+			case videohub.LockUnlocked:
+				released[c.Destination] = true
+			case videohub.LockOwned:
+				if !released[c.Destination] {
+					t.Errorf("output %d locked again before the reconnect released it", c.Destination)
+				}
+				relocked[c.Destination] = true
+			}
+		case <-timeout:
+			t.Fatalf("locks not taken again after reconnect: released %v, relocked %v", released, relocked)
+		}
+	}
+
+	observer := connect(t, sim)
+	if got, want := observer.Locks(), []string{videohub.LockUnlocked, videohub.LockLocked, videohub.LockUnlocked, videohub.LockLocked}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Locks() of another client = %q, want %q", got, want)
+	}
+}
+
+func TestRestoreSkipsOutputsLockedByOthers(t *testing.T) {
+	sim := startSimulator(t, "127.0.0.1:0", simulator.Config{Inputs: 4, Outputs: 4})
+	holder := connect(t, sim)
+	if err := holder.LockOutput(1); err != nil {
+		t.Fatal(err)
+	}
+	vh := connect(t, sim)
+	deadline := time.Now().Add(time.Second)
+	for vh.LockState(1) != videohub.LockLocked && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	snap := vh.Snapshot()
+	snap.Routing = []int{0, 3, 0, 3}
+	err := vh.Restore(snap, videohub.WithRoutingOnly())
+	if !errors.Is(err, videohub.ErrOutputLocked) {
+		t.Fatalf("Restore = %v, want ErrOutputLocked", err)
+	}
+	if want := "routes to outputs [1] not restored"; !strings.Contains(err.Error(), want) {
+		t.Errorf("Restore = %q, want it to list output 1", err)
+	}
+	if got, want := sim.Routing(), []int{0, 1, 0, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Routing() after Restore = %v, want %v", got, want)
+	}
+}
+
+func TestChangesWhileDisconnectedReportedAfterReconnect(t *testing.T) {
+	cfg := simulator.Config{UniqueID: "7C2E0D00000A", Inputs: 4, Outputs: 4}
+	first := startSimulator(t, "127.0.0.1:0", cfg)
+	addr := first.Addr().String()
+	vh := connect(t, first)
+	routes := make(chan [2]int, 16)
+	vh.OnRouteChange(func(destination, source int) { routes <- [2]int{destination, source} })
+	labels := make(chan string, 16)
+	vh.OnLabelChange(func(kind videohub.LabelKind, index int, label string) { labels <- label })
+
+	first.Close()
+	// The same device comes back with a route and a label changed while
+	// the connection was down.
+	second := simulator.New(cfg)
+	second.Route(2, 3)
+	second.SetOutputLabel(1, "Program")
+	if err := second.Start(addr); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { second.Close() })
+
+	select {
+	case r := <-routes:
+		if r != [2]int{2, 3} {
+			t.Errorf("OnRouteChange(%d, %d), want (2, 3)", r[0], r[1])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("route changed while disconnected not reported")
+	}
+	select {
+	case label := <-labels:
+		if label != "Program" {
+			t.Errorf("OnLabelChange label %q, want \"Program\"", label)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("label changed while disconnected not reported")
+	}
+	select {
+	case r := <-routes:
+		t.Errorf("unchanged route reported: %v", r)
+	case label := <-labels:
+		t.Errorf("unchanged label reported: %q", label)
+	default:
+	}
+}
+
+func TestApplyPresetReport(t *testing.T) {
+	// Six outputs on four inputs start routed 0 1 2 3 0 1.
+	sim := startSimulator(t, "127.0.0.1:0", simulator.Config{Inputs: 4, Outputs: 6})
+	holder := connect(t, sim)
+	if err := holder.LockOutput(1); err != nil {
+		t.Fatal(err)
+	}
+	vh := connect(t, sim)
+	deadline := time.Now().Add(time.Second)
+	for vh.LockState(1) != videohub.LockLocked && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	report, err := vh.ApplyPreset(videohub.Preset{Name: "show", Routing: []int{-1, 3, 1, 7, 0, 2, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := videohub.ApplyReport{
+		Changed:   []videohub.RouteDiff{{Destination: 2, From: 2, To: 1}, {Destination: 5, From: 1, To: 2}},
+		Unchanged: 1,
+		Locked:    []int{1},
+		Invalid:   []int{3, 6},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("ApplyPreset report %+v, want %+v", report, want)
+	}
+	if got, want := sim.Routing(), []int{0, 1, 1, 3, 0, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("routing after ApplyPreset %v, want %v", got, want)
+	}
+
+	// Applying the captured routing again changes nothing.
+	deadline = time.Now().Add(time.Second)
+	for !reflect.DeepEqual(vh.Routing(), sim.Routing()) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	report, err = vh.ApplyPreset(vh.CapturePreset("now"))
+	if err != nil || len(report.Changed) != 0 || report.Unchanged != 6 {
+		t.Errorf("ApplyPreset of the current routing = %+v, %v; want 6 unchanged", report, err)
+	}
+}
+
+// recording collects what WithRecorder writes, for a test to read while the
+// Videohub writes to it.
+type recording struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (r *recording) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buf.Write(p)
+}
+
+func (r *recording) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buf.String()
+}
+
+// sent returns the lines recorded as sent to the device, without their
+// timestamps.
+func (r *recording) sent() []string {
+	var lines []string
+	for _, line := range strings.Split(r.String(), "\n") {
+		if _, rest, ok := strings.Cut(line, " "); ok && strings.HasPrefix(rest, ">") {
+			lines = append(lines, strings.TrimPrefix(rest[1:], " "))
+		}
+	}
+	return lines
+}
+
+func TestApplyConfig(t *testing.T) {
+	sim := startSimulator(t, "127.0.0.1:0", simulator.Config{Inputs: 4, Outputs: 4})
+	holder := connect(t, sim)
+	if err := holder.LockOutput(2); err != nil {
+		t.Fatal(err)
+	}
+	var rec recording
+	vh := connect(t, sim, videohub.WithRecorder(&rec))
+	deadline := time.Now().Add(time.Second)
+	for vh.LockState(2) != videohub.LockLocked && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	cfg := videohub.Config{
+		InputLabels:  map[int]string{0: "Input 1", 2: "Camera C"},
+		OutputLabels: map[int]string{1: "Output 2"},
+		Routing:      map[int]int{0: 0, 2: 2, 3: 1},
+	}
+	report, err := vh.ApplyConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := videohub.ConfigReport{
+		InputLabels: []videohub.LabelDiff{{Index: 2, From: "Input 3", To: "Camera C"}},
+		Routes:      []videohub.RouteDiff{{Destination: 3, From: 3, To: 1}},
+		Unchanged:   4,
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("ApplyConfig report %+v, want %+v", report, want)
+	}
+	if report.Changed() != 2 {
+		t.Errorf("Changed() = %d, want 2", report.Changed())
+	}
+	// Only the differences are sent, in one block per kind.
+	if got, want := rec.sent(), []string{"INPUT LABELS:", "2 Camera C", "", "VIDEO OUTPUT ROUTING:", "3 1", ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sent %q, want %q", got, want)
+	}
+	if got := sim.InputLabels()[2]; got != "Camera C" {
+		t.Errorf("input 2 label %q, want \"Camera C\"", got)
+	}
+	if got, want := sim.Routing(), []int{0, 1, 2, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("routing %v, want %v", got, want)
+	}
+
+	// Once in effect, the same config sends nothing.
+	deadline = time.Now().Add(time.Second)
+	for (vh.InputLabels()[2] != "Camera C" || vh.Routing()[3] != 1) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	sent := len(rec.sent())
+	if report, err = vh.ApplyConfig(cfg); err != nil || report.Changed() != 0 || report.Unchanged != 6 {
+		t.Errorf("ApplyConfig again = %+v, %v; want 6 unchanged", report, err)
+	}
+
+	// A config that cannot be applied in full is not applied at all.
+	for name, bad := range map[string]videohub.Config{
+		"locked output": {InputLabels: map[int]string{1: "Camera B"}, Routing: map[int]int{2: 0}},
+		"missing input": {InputLabels: map[int]string{1: "Camera B"}, Routing: map[int]int{1: 4}},
+		"bad label":     {InputLabels: map[int]string{1: "Camera B"}, OutputLabels: map[int]string{0: "two\nlines"}},
+	} {
+		if report, err := vh.ApplyConfig(bad); err == nil || report.Changed() != 0 {
+			t.Errorf("%s: ApplyConfig = %+v, %v; want an error and no changes", name, report, err)
+		}
+	}
+	if got := rec.sent(); len(got) != sent {
+		t.Errorf("sent %q after the repeated and invalid configs", got[sent:])
+	}
+
+	if err := vh.BulkOutputLabels(map[int]string{3: "PGM", 0: "PVW"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rec.sent()[sent:], []string{"OUTPUT LABELS:", "0 PVW", "3 PGM", ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("BulkOutputLabels sent %q, want %q", got, want)
+	}
+}
+
+func TestMaxRetries(t *testing.T) {
+	sim := startSimulator(t, "127.0.0.1:0", simulator.Config{Inputs: 4, Outputs: 4})
+	var dials atomic.Int32
+	vh := connect(t, sim, videohub.WithMaxRetries(2), videohub.WithDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		dials.Add(1)
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", addr)
+	}))
+	events, cancel := vh.Subscribe()
+	defer cancel()
+
+	// Nothing listens on the port any more, so both reconnect attempts fail.
+	sim.Close()
+	timeout := time.After(5 * time.Second)
+	var states []videohub.ConnectionState
+	for len(states) == 0 || states[len(states)-1] != videohub.StateFailed {
+		select {
+		case ev := <-events:
+			if c, ok := ev.(videohub.ConnectionChange); ok {
+				states = append(states, c.New)
+			}
+		case <-timeout:
+			t.Fatalf("states %v, never reached StateFailed", states)
+		}
+	}
+	if want := []videohub.ConnectionState{videohub.StateReconnecting, videohub.StateFailed}; !reflect.DeepEqual(states, want) {
+		t.Errorf("states %v, want %v", states, want)
+	}
+	// One dial for the initial connection, then the two retries.
+	if n := dials.Load(); n != 3 {
+		t.Errorf("%d dials, want 3", n)
+	}
+	if vh.Connected() {
+		t.Error("Connected() after giving up")
+	}
+	if err := vh.Route(0, 1); !errors.Is(err, videohub.ErrReconnectFailed) {
+		t.Errorf("Route after giving up = %v, want ErrReconnectFailed", err)
+	}
+	time.Sleep(600 * time.Millisecond)
+	if n := dials.Load(); n != 3 {
+		t.Errorf("%d dials after giving up, want no more than 3", n)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	sim := startSimulator(t, "127.0.0.1:0", simulator.Config{UniqueID: "7C2E0D00000C", Inputs: 4, Outputs: 4})
+	var rec recording
+	vh := connect(t, sim, videohub.WithRecorder(&rec))
+	if err := vh.Route(2, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := vh.OutputLabel(2, "Program"); err != nil {
+		t.Fatal(err)
+	}
+	if err := vh.LockOutput(3); err != nil {
+		t.Fatal(err)
+	}
+	// Changes made on the device are recorded too.
+	sim.Route(1, 3)
+	sim.SetInputLabel(3, "Camera D")
+	deadline := time.Now().Add(time.Second)
+	for (vh.Routing()[1] != 3 || vh.InputLabels()[3] != "Camera D" || vh.LockState(3) != videohub.LockOwned) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	want := vh.Snapshot()
+	vh.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	replayed, err := videohub.Replay(ctx, strings.NewReader(rec.String()), videohub.WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayed.Close()
+	got := replayed.Snapshot()
+	if got.UniqueID != "7C2E0D00000C" || got.UniqueID != want.UniqueID {
+		t.Errorf("replayed unique ID %q, want %q", got.UniqueID, want.UniqueID)
+	}
+	if !reflect.DeepEqual(got.Routing, want.Routing) || !reflect.DeepEqual(got.Routing, []int{0, 3, 0, 3}) {
+		t.Errorf("replayed routing %v, want %v", got.Routing, want.Routing)
+	}
+	if !reflect.DeepEqual(got.InputLabels, want.InputLabels) || !reflect.DeepEqual(got.OutputLabels, want.OutputLabels) {
+		t.Errorf("replayed labels %q %q, want %q %q", got.InputLabels, got.OutputLabels, want.InputLabels, want.OutputLabels)
+	}
+	if !reflect.DeepEqual(got.Locks, want.Locks) {
+		t.Errorf("replayed locks %q, want %q", got.Locks, want.Locks)
+	}
+	if err := replayed.WaitForReady(time.Second); err != nil {
+		t.Errorf("replayed Videohub not ready: %v", err)
+	}
+}
+
+func TestReplayCanceled(t *testing.T) {
+	// A recording that never ends.
+	r, w := io.Pipe()
+	defer w.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := videohub.Replay(ctx, r, videohub.WithLogger(nil)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Replay with a canceled context = %v, want context.Canceled", err)
+	}
+}
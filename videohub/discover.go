@@ -0,0 +1,263 @@
+package videohub
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+// DefaultDiscoverTimeout is how long Discover listens for answers when ctx
+// has no deadline.
+const DefaultDiscoverTimeout = 3 * time.Second
+
+// discoverServices are the DNS-SD service types Videohubs advertise.
+var discoverServices = []string{"_videohub._tcp.local.", "_blackmagic._tcp.local."}
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+const (
+	dnsTypeA   = 1
+	dnsTypePTR = 12
+	dnsTypeTXT = 16
+	dnsTypeSRV = 33
+	dnsClassIN = 1
+	dnsQU      = 0x8000 // Ask for a unicast response
+)
+
+// Discover browses the local network over mDNS for Videohubs until ctx is
+// done (or for DefaultDiscoverTimeout if ctx has no deadline) and returns the
+// devices that answered. Only Address, Model, UniqueID and ProtocolVersion
+// are filled in, as far as the device advertises them; Address can be passed
+// straight to NewVideohub.
+func Discover(ctx context.Context) ([]DeviceInfo, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultDiscoverTimeout)
+		defer cancel()
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if _, err := conn.WriteToUDP(buildMDNSQuery(discoverServices), mdnsGroup); err != nil {
+		return nil, err
+	}
+
+	var found []DeviceInfo
+	seen := make(map[string]bool)
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return found, nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return found, nil
+			}
+			return found, err
+		}
+		for _, info := range parseMDNSResponse(buf[:n], from.IP) {
+			key := info.UniqueID
+			if key == "" {
+				key = info.Address
+			}
+			if !seen[key] {
+				seen[key] = true
+				found = append(found, info)
+			}
+		}
+	}
+}
+
+func buildMDNSQuery(services []string) []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[4:], uint16(len(services)))
+	for _, service := range services {
+		for _, label := range strings.Split(strings.TrimSuffix(service, "."), ".") {
+			msg = append(msg, byte(len(label)))
+			msg = append(msg, label...)
+		}
+		msg = append(msg, 0)
+		msg = binary.BigEndian.AppendUint16(msg, dnsTypePTR)
+		msg = binary.BigEndian.AppendUint16(msg, dnsClassIN|dnsQU)
+	}
+	return msg
+}
+
+// mdnsInstance collects the records of one advertised service instance.
+type mdnsInstance struct {
+	service string
+	target  string
+	txt     map[string]string
+}
+
+// parseMDNSResponse extracts the Videohubs described by one mDNS response.
+// Devices whose address record is missing are assumed to be the sender.
+func parseMDNSResponse(msg []byte, from net.IP) []DeviceInfo {
+	records, ok := parseDNSRecords(msg)
+	if !ok {
+		return nil
+	}
+	instances := make(map[string]*mdnsInstance)
+	instance := func(name string) *mdnsInstance {
+		key := strings.ToLower(name)
+		if instances[key] == nil {
+			instances[key] = &mdnsInstance{txt: make(map[string]string)}
+		}
+		return instances[key]
+	}
+	hosts := make(map[string]net.IP)
+	for _, rr := range records {
+		switch rr.typ {
+		case dnsTypePTR:
+			if target, _, ok := readDNSName(msg, rr.offset); ok {
+				instance(target).service = strings.ToLower(rr.name)
+			}
+		case dnsTypeSRV:
+			if len(rr.data) >= 6 {
+				if target, _, ok := readDNSName(msg, rr.offset+6); ok {
+					instance(rr.name).target = strings.ToLower(target)
+				}
+			}
+		case dnsTypeTXT:
+			in := instance(rr.name)
+			for data := rr.data; len(data) > 0 && int(data[0]) < len(data); data = data[1+int(data[0]):] {
+				key, value, _ := strings.Cut(string(data[1:1+int(data[0])]), "=")
+				in.txt[strings.ToLower(key)] = value
+			}
+		case dnsTypeA:
+			if len(rr.data) == 4 {
+				hosts[strings.ToLower(rr.name)] = net.IP(rr.data)
+			}
+		}
+	}
+
+	var devices []DeviceInfo
+	for name, in := range instances {
+		if !isVideohubInstance(name, in) {
+			continue
+		}
+		ip := hosts[in.target]
+		if ip == nil {
+			ip = from
+		}
+		info := DeviceInfo{
+			Address:         ip.String(),
+			Model:           in.txt["name"],
+			UniqueID:        strings.ToUpper(in.txt["unique id"]),
+			ProtocolVersion: in.txt["protocol version"],
+		}
+		if info.Model == "" {
+			info.Model, _, _ = strings.Cut(name, ".")
+		}
+		devices = append(devices, info)
+	}
+	return devices
+}
+
+// isVideohubInstance reports whether an instance belongs to a Videohub. Other
+// Blackmagic products also advertise _blackmagic._tcp, so for that service the
+// TXT class must name a Videohub when present.
+func isVideohubInstance(name string, in *mdnsInstance) bool {
+	switch {
+	case strings.HasSuffix(name, "._videohub._tcp.local."):
+		return true
+	case strings.HasSuffix(name, "._blackmagic._tcp.local."):
+		class, ok := in.txt["class"]
+		return !ok || strings.Contains(strings.ToLower(class), "videohub")
+	}
+	return false
+}
+
+type dnsRecord struct {
+	name   string
+	typ    uint16
+	offset int // Start of data within the message, for names in the data
+	data   []byte
+}
+
+// parseDNSRecords returns the answer, authority and additional records of a
+// DNS message.
+func parseDNSRecords(msg []byte) ([]dnsRecord, bool) {
+	if len(msg) < 12 {
+		return nil, false
+	}
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	count := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+	off := 12
+	for i := 0; i < questions; i++ {
+		_, next, ok := readDNSName(msg, off)
+		if !ok || next+4 > len(msg) {
+			return nil, false
+		}
+		off = next + 4
+	}
+	records := make([]dnsRecord, 0, count)
+	for i := 0; i < count; i++ {
+		name, next, ok := readDNSName(msg, off)
+		if !ok || next+10 > len(msg) {
+			return records, len(records) > 0
+		}
+		length := int(binary.BigEndian.Uint16(msg[next+8:]))
+		start := next + 10
+		if start+length > len(msg) {
+			return records, len(records) > 0
+		}
+		records = append(records, dnsRecord{
+			name:   name,
+			typ:    binary.BigEndian.Uint16(msg[next:]),
+			offset: start,
+			data:   msg[start : start+length],
+		})
+		off = start + length
+	}
+	return records, true
+}
+
+// readDNSName decodes the possibly compressed name at off and returns it with
+// a trailing dot, and the offset just past it.
+func readDNSName(msg []byte, off int) (string, int, bool) {
+	var b strings.Builder
+	end := -1
+	for jumps := 0; jumps < 32; {
+		if off >= len(msg) {
+			return "", 0, false
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			if b.Len() == 0 {
+				b.WriteString(".")
+			}
+			return b.String(), end, true
+		case n&0xC0 == 0xC0:
+			if off+1 >= len(msg) {
+				return "", 0, false
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+			jumps++
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, false
+			}
+			b.Write(msg[off+1 : off+1+n])
+			b.WriteString(".")
+			off += 1 + n
+		}
+	}
+	return "", 0, false
+}
@@ -0,0 +1,135 @@
+package videohub
+
+import (
+	"math/rand/v2"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// smartVideohubResponse is an mDNS response in the form a Smart Videohub
+// answers the Discover query with, names compressed as the device sends them.
+var smartVideohubResponse = []byte{
+	// Header: response, 1 answer, 3 additional records
+	0x00, 0x00, 0x84, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x03,
+	// PTR _videohub._tcp.local.
+	0x09, 0x5f, 0x76, 0x69, 0x64, 0x65, 0x6f, 0x68, 0x75, 0x62, 0x04, 0x5f, 0x74, 0x63, 0x70, 0x05,
+	0x6c, 0x6f, 0x63, 0x61, 0x6c, 0x00, 0x00, 0x0c, 0x00, 0x01, 0x00, 0x00, 0x11, 0x94, 0x00, 0x15,
+	// -> Smart Videohub 12G.<pointer to 12>
+	0x12, 0x53, 0x6d, 0x61, 0x72, 0x74, 0x20, 0x56, 0x69, 0x64, 0x65, 0x6f, 0x68, 0x75, 0x62, 0x20,
+	0x31, 0x32, 0x47, 0xc0, 0x0c,
+	// SRV <pointer to 44>, port 9990
+	0xc0, 0x2c, 0x00, 0x21, 0x80, 0x01, 0x00, 0x00, 0x00, 0x78, 0x00, 0x1e, 0x00, 0x00, 0x00, 0x00,
+	0x27, 0x06,
+	// -> Videohub-7C2E0D021B5A.<pointer to local.>
+	0x15, 0x56, 0x69, 0x64, 0x65, 0x6f, 0x68, 0x75, 0x62, 0x2d, 0x37, 0x43, 0x32, 0x45, 0x30, 0x44,
+	0x30, 0x32, 0x31, 0x42, 0x35, 0x41, 0xc0, 0x1b,
+	// TXT <pointer to 44>
+	0xc0, 0x2c, 0x00, 0x10, 0x80, 0x01, 0x00, 0x00, 0x11, 0x94, 0x00, 0x59,
+	// name=..., protocol version=2.8, unique id=..., class=Videohub
+	0x1d, 0x6e, 0x61, 0x6d, 0x65, 0x3d, 0x53, 0x6d, 0x61, 0x72, 0x74, 0x20, 0x56, 0x69, 0x64, 0x65,
+	0x6f, 0x68, 0x75, 0x62, 0x20, 0x31, 0x32, 0x47, 0x20, 0x34, 0x30, 0x78, 0x34, 0x30, 0x14, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x20, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x3d,
+	0x32, 0x2e, 0x38, 0x16, 0x75, 0x6e, 0x69, 0x71, 0x75, 0x65, 0x20, 0x69, 0x64, 0x3d, 0x37, 0x63,
+	0x32, 0x65, 0x30, 0x64, 0x30, 0x32, 0x31, 0x62, 0x35, 0x61, 0x0e, 0x63, 0x6c, 0x61, 0x73, 0x73,
+	0x3d, 0x56, 0x69, 0x64, 0x65, 0x6f, 0x68, 0x75, 0x62,
+	// A <pointer to 83>: 192.168.1.50
+	0xc0, 0x53, 0x00, 0x01, 0x80, 0x01, 0x00, 0x00, 0x00, 0x78, 0x00, 0x04, 0xc0, 0xa8, 0x01, 0x32,
+}
+
+// aRecordOffset is where the A record starts in smartVideohubResponse.
+const aRecordOffset = 208
+
+func TestParseMDNSResponse(t *testing.T) {
+	want := []DeviceInfo{{
+		Address:         "192.168.1.50",
+		Model:           "Smart Videohub 12G 40x40",
+		UniqueID:        "7C2E0D021B5A",
+		ProtocolVersion: "2.8",
+	}}
+	if got := parseMDNSResponse(smartVideohubResponse, net.IPv4(10, 0, 0, 9)); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseMDNSResponse = %+v, want %+v", got, want)
+	}
+
+	// Without the A record the device is assumed to be the sender.
+	msg := append([]byte(nil), smartVideohubResponse[:aRecordOffset]...)
+	msg[11] = 2
+	want[0].Address = "10.0.0.9"
+	if got := parseMDNSResponse(msg, net.IPv4(10, 0, 0, 9)); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseMDNSResponse without A record = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseMDNSResponseOtherService(t *testing.T) {
+	// The same answer for an unrelated service type is ignored.
+	msg := append([]byte(nil), smartVideohubResponse...)
+	copy(msg[13:22], "_printers")
+	if got := parseMDNSResponse(msg, net.IPv4(10, 0, 0, 9)); got != nil {
+		t.Errorf("parseMDNSResponse for _printers._tcp = %+v, want none", got)
+	}
+}
+
+func TestParseDNSRecordsQuery(t *testing.T) {
+	records, ok := parseDNSRecords(buildMDNSQuery(discoverServices))
+	if !ok || len(records) != 0 {
+		t.Errorf("parseDNSRecords of the query = %v, %v; want no records", records, ok)
+	}
+}
+
+func TestReadDNSName(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  []byte
+		off  int
+		want string
+		next int
+		ok   bool
+	}{
+		{"plain", []byte{3, 'f', 'o', 'o', 5, 'l', 'o', 'c', 'a', 'l', 0}, 0, "foo.local.", 11, true},
+		{"root", []byte{0}, 0, ".", 1, true},
+		{"pointer", []byte{5, 'l', 'o', 'c', 'a', 'l', 0, 3, 'f', 'o', 'o', 0xC0, 0x00}, 7, "foo.local.", 13, true},
+		{"pointer to pointer", []byte{1, 'a', 0, 0xC0, 0x00, 1, 'b', 0xC0, 0x03}, 5, "b.a.", 9, true},
+		{"pointer to itself", []byte{1, 'a', 0xC0, 0x02}, 0, "", 0, false},
+		{"pointers to each other", []byte{0xC0, 0x02, 0xC0, 0x00}, 0, "", 0, false},
+		{"pointer past the end", []byte{0xC0, 0x10}, 0, "", 0, false},
+		{"truncated pointer", []byte{1, 'a', 0xC0}, 0, "", 0, false},
+		{"truncated label", []byte{5, 'l', 'o', 'c'}, 0, "", 0, false},
+		{"missing terminator", []byte{1, 'a'}, 0, "", 0, false},
+		{"offset past the end", []byte{0}, 4, "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, next, ok := readDNSName(tt.msg, tt.off)
+			if got != tt.want || next != tt.next || ok != tt.ok {
+				t.Errorf("readDNSName = %q, %d, %v; want %q, %d, %v", got, next, ok, tt.want, tt.next, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParseMDNSResponseMalformed(t *testing.T) {
+	from := net.IPv4(10, 0, 0, 9)
+	// Every truncation of a valid response.
+	for n := range smartVideohubResponse {
+		parseMDNSResponse(smartVideohubResponse[:n], from)
+	}
+	// Counts far larger than the records present.
+	msg := append([]byte(nil), smartVideohubResponse...)
+	copy(msg[4:], []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+	if got := parseMDNSResponse(msg, from); got != nil {
+		t.Errorf("parseMDNSResponse with bogus counts = %+v", got)
+	}
+	// The instance name in the PTR record pointing at itself.
+	msg = append([]byte(nil), smartVideohubResponse...)
+	copy(msg[44:], []byte{0xC0, 44})
+	parseMDNSResponse(msg, from)
+	// Random corruption.
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := 0; i < 2000; i++ {
+		msg = append(msg[:0], smartVideohubResponse...)
+		for j := 0; j < 4; j++ {
+			msg[rng.IntN(len(msg))] = byte(rng.Uint32())
+		}
+		parseMDNSResponse(msg, from)
+	}
+}
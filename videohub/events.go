@@ -0,0 +1,251 @@
+package videohub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LabelKind tells input labels apart from output labels in label events.
+type LabelKind int
+
+const (
+	LabelInput LabelKind = iota
+	LabelOutput
+	LabelMonitoringOutput
+	LabelSerialPort
+	LabelFrame
+)
+
+func (k LabelKind) String() string {
+	switch k {
+	case LabelInput:
+		return "input"
+	case LabelOutput:
+		return "output"
+	case LabelMonitoringOutput:
+		return "monitoring output"
+	case LabelSerialPort:
+		return "serial port"
+	case LabelFrame:
+		return "frame"
+	}
+	return "unknown"
+}
+
+// Event is delivered by Subscribe. It is one of RouteChange, LabelChange,
+// LockChange, ForcedUnlock, ConnectionChange, DeviceChange, DimensionsChange,
+// PowerSupplyFault or KeepaliveFailed.
+type Event interface {
+	isEvent()
+}
+
+// RouteChange reports that Destination is now fed by Source instead of
+// Previous.
+type RouteChange struct {
+	Destination int       `json:"destination"`
+	Source      int       `json:"source"`
+	Previous    int       `json:"previous"`
+	Time        time.Time `json:"time"`
+}
+
+// LabelChange reports a new label for an input, output, monitoring output,
+// serial port or frame.
+type LabelChange struct {
+	Kind     LabelKind `json:"kind"`
+	Index    int       `json:"index"`
+	Label    string    `json:"label"`
+	Previous string    `json:"previous"`
+	Time     time.Time `json:"time"`
+}
+
+// LockChange reports that the lock state of output Destination changed from
+// Previous to State, one of LockUnlocked, LockOwned or LockLocked. The lock
+// dump sent on connect does not produce it.
+type LockChange struct {
+	Destination int       `json:"destination"`
+	State       string    `json:"state"`
+	Previous    string    `json:"previous"`
+	Time        time.Time `json:"time"`
+}
+
+// ForcedUnlock reports that this client force unlocked output Destination,
+// whose lock state was Previous.
+type ForcedUnlock struct {
+	Destination int       `json:"destination"`
+	Previous    string    `json:"previous"`
+	Time        time.Time `json:"time"`
+}
+
+// ConnectionChange reports a transition of the connection state.
+type ConnectionChange struct {
+	Old, New ConnectionState
+}
+
+// DeviceChange reports that a different device now answers at the address.
+type DeviceChange struct {
+	OldID, NewID string
+}
+
+// DimensionsChange reports new input and output counts.
+type DimensionsChange struct {
+	OldInputs, OldOutputs int
+	Inputs, Outputs       int
+}
+
+// PowerSupplyFault reports a power supply that failed or was removed.
+type PowerSupplyFault struct {
+	PowerSupply PowerSupply
+}
+
+// KeepaliveFailed reports that the device did not answer a keepalive PING and
+// the connection is being re-established.
+type KeepaliveFailed struct {
+	LastSeen time.Time // When anything was last received from the device
+	Err      error
+}
+
+func (RouteChange) isEvent()      {}
+func (LabelChange) isEvent()      {}
+func (LockChange) isEvent()       {}
+func (ForcedUnlock) isEvent()     {}
+func (ConnectionChange) isEvent() {}
+func (DeviceChange) isEvent()     {}
+func (DimensionsChange) isEvent() {}
+func (PowerSupplyFault) isEvent() {}
+func (KeepaliveFailed) isEvent()  {}
+
+// subscriberBuffer is the number of events a subscriber may fall behind
+// before further events are dropped for it.
+const subscriberBuffer = 64
+
+// Subscribe returns a channel receiving every event, and a function that
+// cancels the subscription and closes the channel. The channel is also closed
+// by Close. Events are delivered without blocking the reader: if a subscriber
+// falls more than subscriberBuffer events behind, newer events are dropped for
+// it and a message is logged.
+func (vh *Videohub) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+	vh.handlersMu.Lock()
+	defer vh.handlersMu.Unlock()
+	if vh.subscribers == nil {
+		vh.subscribers = make(map[chan Event]struct{})
+	}
+	if vh.closed() {
+		close(ch)
+		return ch, func() {}
+	}
+	vh.subscribers[ch] = struct{}{}
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			vh.handlersMu.Lock()
+			defer vh.handlersMu.Unlock()
+			if _, ok := vh.subscribers[ch]; ok {
+				delete(vh.subscribers, ch)
+				close(ch)
+			}
+		})
+	}
+}
+
+// WaitForChange returns the next event for which match returns true, or the
+// next event of any kind if match is nil. It returns ErrTimeout if the
+// deadline of ctx passes first, ctx.Err() if ctx is cancelled and ErrClosed
+// if the Videohub is closed. Only events published after the call are seen.
+func (vh *Videohub) WaitForChange(ctx context.Context, match func(Event) bool) (Event, error) {
+	events, unsubscribe := vh.Subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return nil, ErrClosed
+			}
+			if match == nil || match(ev) {
+				return ev, nil
+			}
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, ErrTimeout
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (vh *Videohub) publish(ev Event) {
+	vh.handlersMu.Lock()
+	defer vh.handlersMu.Unlock()
+	for ch := range vh.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			vh.logger.Warn("Dropping event for slow subscriber", "event", fmt.Sprintf("%T", ev))
+		}
+	}
+}
+
+// closeSubscribers ends every subscription; called by Close.
+func (vh *Videohub) closeSubscribers() {
+	vh.handlersMu.Lock()
+	defer vh.handlersMu.Unlock()
+	for ch := range vh.subscribers {
+		delete(vh.subscribers, ch)
+		close(ch)
+	}
+}
+
+// OnRouteChange registers fn to be called whenever the device reports that
+// destination is now fed by a different source, whether the change was made by
+// this client, another controller or the front panel. The initial routing dump
+// sent on connect does not trigger it, but the dump after a reconnect does for
+// every route that changed while the connection was down.
+func (vh *Videohub) OnRouteChange(fn func(destination, source int)) {
+	vh.handlersMu.Lock()
+	defer vh.handlersMu.Unlock()
+	vh.routeHandlers = append(vh.routeHandlers, fn)
+}
+
+// OnLabelChange registers fn to be called whenever the device reports a new
+// label for an input or output. The first label dump of each kind received
+// after connecting does not trigger it; the dumps after a reconnect do for
+// every label that changed while the connection was down.
+func (vh *Videohub) OnLabelChange(fn func(kind LabelKind, index int, label string)) {
+	vh.handlersMu.Lock()
+	defer vh.handlersMu.Unlock()
+	vh.labelHandlers = append(vh.labelHandlers, fn)
+}
+
+func (vh *Videohub) emitRouteChanges(changes []RouteChange) {
+	if len(changes) == 0 {
+		return
+	}
+	vh.metrics.crosspointChanges.Add(uint64(len(changes)))
+	vh.handlersMu.Lock()
+	handlers := append([]func(destination, source int){}, vh.routeHandlers...)
+	vh.handlersMu.Unlock()
+	for _, c := range changes {
+		for _, fn := range handlers {
+			fn(c.Destination, c.Source)
+		}
+		vh.publish(c)
+	}
+}
+
+func (vh *Videohub) emitLabelChanges(changes []LabelChange) {
+	if len(changes) == 0 {
+		return
+	}
+	vh.handlersMu.Lock()
+	handlers := append([]func(kind LabelKind, index int, label string){}, vh.labelHandlers...)
+	vh.handlersMu.Unlock()
+	for _, c := range changes {
+		for _, fn := range handlers {
+			fn(c.Kind, c.Index, c.Label)
+		}
+		vh.publish(c)
+	}
+}
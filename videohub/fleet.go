@@ -0,0 +1,264 @@
+package videohub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Fleet manages several Videohubs, keyed by the unique ID each device reports.
+// Every Videohub keeps reconnecting on its own, so one device going down does
+// not affect the others.
+//
+// Ports are addressed as "device/port", where device is a unique ID or the
+// address the Videohub was created with, and port is a zero-based index or a
+// label matched as in InputIndex and OutputIndex.
+type Fleet struct {
+	mu   sync.RWMutex
+	hubs map[string]*fleetMember
+
+	subsMu      sync.Mutex
+	subscribers map[chan FleetEvent]struct{}
+	forwarders  sync.WaitGroup
+}
+
+type fleetMember struct {
+	vh          *Videohub
+	id          string
+	unsubscribe func()
+}
+
+// FleetEvent is an event of one Videohub of a Fleet.
+type FleetEvent struct {
+	Device string // Unique ID of the device at the time of the event
+	Event  Event
+}
+
+// NewFleet returns an empty Fleet.
+func NewFleet() *Fleet {
+	return &Fleet{
+		hubs:        make(map[string]*fleetMember),
+		subscribers: make(map[chan FleetEvent]struct{}),
+	}
+}
+
+// Connect connects to the Videohub at ip, waits until ctx is done for its
+// initial state dump and adds it to the fleet.
+func (f *Fleet) Connect(ctx context.Context, ip string, opts ...Option) (*Videohub, error) {
+	vh, err := NewVideohubContext(ctx, ip, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := vh.WaitReady(ctx); err != nil {
+		vh.Close()
+		return nil, err
+	}
+	if err := f.Add(vh); err != nil {
+		vh.Close()
+		return nil, err
+	}
+	return vh, nil
+}
+
+// Add adds a Videohub that has received its device information. If the
+// device is later replaced by one with a different unique ID, the fleet
+// follows the new ID.
+func (f *Fleet) Add(vh *Videohub) error {
+	id := vh.UniqueID()
+	if id == "" {
+		return fmt.Errorf("videohub: %s has not reported its unique ID yet", vh.ip)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.hubs[id]; ok {
+		return fmt.Errorf("videohub: device %s is already in the fleet", id)
+	}
+	events, unsubscribe := vh.Subscribe()
+	m := &fleetMember{vh: vh, id: id, unsubscribe: unsubscribe}
+	f.hubs[id] = m
+	f.forwarders.Add(1)
+	go f.forward(m, events)
+	return nil
+}
+
+// Remove takes the device with unique ID id out of the fleet and returns it
+// without closing it.
+func (f *Fleet) Remove(id string) (*Videohub, bool) {
+	f.mu.Lock()
+	m, ok := f.hubs[id]
+	if ok {
+		delete(f.hubs, id)
+	}
+	f.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	m.unsubscribe()
+	return m.vh, true
+}
+
+// Get returns the device with unique ID id.
+func (f *Fleet) Get(id string) (*Videohub, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	m, ok := f.hubs[id]
+	if !ok {
+		return nil, false
+	}
+	return m.vh, true
+}
+
+// Devices returns the unique IDs of the devices in the fleet, sorted.
+func (f *Fleet) Devices() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	ids := make([]string, 0, len(f.hubs))
+	for id := range f.hubs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Close closes every Videohub in the fleet and ends all subscriptions.
+func (f *Fleet) Close() error {
+	f.mu.Lock()
+	members := make([]*fleetMember, 0, len(f.hubs))
+	for id, m := range f.hubs {
+		members = append(members, m)
+		delete(f.hubs, id)
+	}
+	f.mu.Unlock()
+
+	var errs []error
+	for _, m := range members {
+		m.unsubscribe()
+		if err := m.vh.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", m.id, err))
+		}
+	}
+	f.forwarders.Wait()
+	f.subsMu.Lock()
+	for ch := range f.subscribers {
+		delete(f.subscribers, ch)
+		close(ch)
+	}
+	f.subsMu.Unlock()
+	return errors.Join(errs...)
+}
+
+// Subscribe returns a channel receiving the events of every device in the
+// fleet, and a function that cancels the subscription. Slow subscribers lose
+// events as with Videohub.Subscribe.
+func (f *Fleet) Subscribe() (<-chan FleetEvent, func()) {
+	ch := make(chan FleetEvent, subscriberBuffer)
+	f.subsMu.Lock()
+	f.subscribers[ch] = struct{}{}
+	f.subsMu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			f.subsMu.Lock()
+			defer f.subsMu.Unlock()
+			if _, ok := f.subscribers[ch]; ok {
+				delete(f.subscribers, ch)
+				close(ch)
+			}
+		})
+	}
+}
+
+// forward relays the events of one member until it is removed or closed,
+// and re-keys it when the device at its address changes.
+func (f *Fleet) forward(m *fleetMember, events <-chan Event) {
+	defer f.forwarders.Done()
+	for ev := range events {
+		f.mu.Lock()
+		if change, ok := ev.(DeviceChange); ok && change.NewID != "" {
+			if f.hubs[m.id] == m {
+				if _, taken := f.hubs[change.NewID]; !taken {
+					delete(f.hubs, m.id)
+					f.hubs[change.NewID] = m
+				}
+			}
+			m.id = change.NewID
+		}
+		id := m.id
+		f.mu.Unlock()
+
+		f.subsMu.Lock()
+		for ch := range f.subscribers {
+			select {
+			case ch <- FleetEvent{Device: id, Event: ev}:
+			default:
+				m.vh.logger.Warn("Dropping event for slow fleet subscriber", "event", fmt.Sprintf("%T", ev))
+			}
+		}
+		f.subsMu.Unlock()
+	}
+}
+
+// Route routes source to destination, both given as "device/port". source
+// may omit the device to use the one of destination; both must be on the same
+// device.
+func (f *Fleet) Route(destination, source string) error {
+	vh, output, err := f.resolve(destination, LabelOutput, nil)
+	if err != nil {
+		return err
+	}
+	src, input, err := f.resolve(source, LabelInput, vh)
+	if err != nil {
+		return err
+	}
+	if src != vh {
+		return fmt.Errorf("videohub: cannot route %q to %q on a different device", source, destination)
+	}
+	return vh.Route(output, input)
+}
+
+// Port resolves a "device/port" address to its Videohub and zero-based index.
+func (f *Fleet) Port(address string, kind LabelKind) (*Videohub, int, error) {
+	return f.resolve(address, kind, nil)
+}
+
+// resolve parses address. Without a device part it refers to fallback, if
+// given.
+func (f *Fleet) resolve(address string, kind LabelKind, fallback *Videohub) (*Videohub, int, error) {
+	device, port, ok := strings.Cut(address, "/")
+	vh := fallback
+	if ok {
+		vh = f.device(device)
+		if vh == nil {
+			return nil, -1, fmt.Errorf("videohub: no device %q in the fleet", device)
+		}
+	} else if vh == nil {
+		return nil, -1, fmt.Errorf("videohub: address %q is not of the form device/port", address)
+	} else {
+		port = address
+	}
+	if i, err := strconv.Atoi(strings.TrimSpace(port)); err == nil {
+		return vh, i, nil
+	}
+	i, err := vh.resolveLabel(kind, port)
+	return vh, i, err
+}
+
+func (f *Fleet) device(name string) *Videohub {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for id, m := range f.hubs {
+		if strings.EqualFold(id, name) {
+			return m.vh
+		}
+	}
+	for _, m := range f.hubs {
+		if m.vh.ip == name {
+			return m.vh
+		}
+	}
+	return nil
+}
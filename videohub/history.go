@@ -0,0 +1,104 @@
+package videohub
+
+// DefaultHistorySize is the number of route changes kept per output unless
+// WithHistorySize says otherwise.
+const DefaultHistorySize = 100
+
+// routeRing holds the most recent route changes of one output.
+type routeRing struct {
+	entries []RouteChange // Oldest at next once full
+	next    int
+}
+
+func (r *routeRing) add(c RouteChange, size int) {
+	if len(r.entries) < size {
+		r.entries = append(r.entries, c)
+		return
+	}
+	r.entries[r.next] = c
+	r.next = (r.next + 1) % size
+}
+
+// last returns up to n entries, oldest first.
+func (r *routeRing) last(n int) []RouteChange {
+	ordered := append(append([]RouteChange{}, r.entries[r.next:]...), r.entries[:r.next]...)
+	if n > 0 && n < len(ordered) {
+		ordered = ordered[len(ordered)-n:]
+	}
+	return ordered
+}
+
+// WithHistorySize keeps the last n route changes of each output for History.
+// Zero disables the history.
+func WithHistorySize(n int) Option {
+	return func(vh *Videohub) {
+		vh.historySize = n
+	}
+}
+
+// History returns up to the n most recent route changes of output, oldest
+// first. n <= 0 returns all that are kept. The history survives reconnects
+// but is discarded when a different device answers. The initial routing dump
+// is not a change and is not recorded.
+func (vh *Videohub) History(output, n int) []RouteChange {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	r, ok := vh.history[output]
+	if !ok {
+		return nil
+	}
+	return r.last(n)
+}
+
+// recordHistory adds changes to the history. The caller must hold vh.mu.
+func (vh *Videohub) recordHistory(changes []RouteChange) {
+	if vh.historySize <= 0 {
+		return
+	}
+	for _, c := range changes {
+		if vh.history == nil {
+			vh.history = make(map[int]*routeRing)
+		}
+		r, ok := vh.history[c.Destination]
+		if !ok {
+			r = &routeRing{}
+			vh.history[c.Destination] = r
+		}
+		r.add(c, vh.historySize)
+	}
+}
+
+// WatchOutput returns a channel receiving every route change of output, and
+// a function that stops the watch and closes the channel. It is
+// SubscribeDestinations for a single output.
+func (vh *Videohub) WatchOutput(output int) (<-chan RouteChange, func()) {
+	return vh.SubscribeDestinations(output)
+}
+
+// SubscribeDestinations returns a channel receiving the route changes of the
+// outputs dests, and a function that cancels the subscription and closes the
+// channel. Like Subscribe, the channel is closed by Close and drops changes
+// if the receiver falls behind.
+func (vh *Videohub) SubscribeDestinations(dests ...int) (<-chan RouteChange, func()) {
+	wanted := make(map[int]bool, len(dests))
+	for _, d := range dests {
+		wanted[d] = true
+	}
+	events, cancel := vh.Subscribe()
+	ch := make(chan RouteChange, subscriberBuffer)
+	go func() {
+		defer close(ch)
+		for ev := range events {
+			c, ok := ev.(RouteChange)
+			if !ok || !wanted[c.Destination] {
+				continue
+			}
+			select {
+			case ch <- c:
+			default:
+				vh.logger.Warn("Dropping route change for slow watcher", "output", c.Destination)
+			}
+		}
+	}()
+	return ch, cancel
+}
@@ -0,0 +1,99 @@
+package videohub
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// sources returns the Source of each change.
+func sources(changes []RouteChange) []int {
+	s := []int{}
+	for _, c := range changes {
+		s = append(s, c.Source)
+	}
+	return s
+}
+
+func TestRouteRing(t *testing.T) {
+	var r routeRing
+	for source := 1; source <= 2; source++ {
+		r.add(RouteChange{Source: source}, 3)
+	}
+	for _, n := range []int{0, 2, 3, 10} {
+		if got := sources(r.last(n)); !reflect.DeepEqual(got, []int{1, 2}) {
+			t.Errorf("partly filled last(%d) = %v, want [1 2]", n, got)
+		}
+	}
+
+	// Seven changes through a ring of three wrap around twice.
+	for source := 3; source <= 7; source++ {
+		r.add(RouteChange{Source: source}, 3)
+	}
+	for n, want := range map[int][]int{0: {5, 6, 7}, 1: {7}, 2: {6, 7}, 3: {5, 6, 7}, 4: {5, 6, 7}, 100: {5, 6, 7}} {
+		if got := sources(r.last(n)); !reflect.DeepEqual(got, want) {
+			t.Errorf("last(%d) = %v, want %v", n, got, want)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	vh, device := pipeHub(t, WithHistorySize(2))
+	writeChunks(device, testDump{4, 4}.text("\n"), 4096)
+	waitReady(t, vh)
+	if h := vh.History(0, 0); h != nil {
+		t.Errorf("History after the initial dump = %+v, want none", h)
+	}
+
+	for _, source := range []int{0, 1, 2} {
+		device.Write([]byte(fmt.Sprintf("VIDEO OUTPUT ROUTING:\n1 %d\n\n", source)))
+	}
+	// Output 1 starts on input 2, so the changes are 2→0, 0→1 and 1→2.
+	eventually(t, "third route change", func() bool {
+		h := vh.History(1, 0)
+		return len(h) == 2 && h[1].Source == 2
+	})
+	h := vh.History(1, 0)
+	if got := sources(h); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Fatalf("History(1, 0) sources %v, want [1 2]", got)
+	}
+	if h[0].Previous != 0 || h[1].Previous != 1 || h[1].Time.Before(h[0].Time) {
+		t.Errorf("History(1, 0) = %+v, want changes 0→1 then 1→2", h)
+	}
+	if got := sources(vh.History(1, 1)); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("History(1, 1) sources %v, want [2]", got)
+	}
+	if h := vh.History(0, 0); h != nil {
+		t.Errorf("History of an unchanged output = %+v, want none", h)
+	}
+}
+
+func TestWatchOutput(t *testing.T) {
+	vh, device := pipeHub(t)
+	writeChunks(device, testDump{4, 4}.text("\n"), 4096)
+	waitReady(t, vh)
+
+	changes, stop := vh.WatchOutput(2)
+	device.Write([]byte("VIDEO OUTPUT ROUTING:\n1 3\n2 3\n\n"))
+	select {
+	case c := <-changes:
+		if c.Destination != 2 || c.Source != 3 {
+			t.Errorf("WatchOutput(2) received %+v", c)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("route change of the watched output not received")
+	}
+
+	stop()
+	for c := range changes {
+		t.Errorf("unexpected %+v after stop", c)
+	}
+	if n := subscribers(vh); n != 0 {
+		t.Errorf("%d subscriptions left after stop", n)
+	}
+	// Stopping twice and changes after stopping are harmless.
+	stop()
+	device.Write([]byte("VIDEO OUTPUT ROUTING:\n2 0\n\n"))
+	eventually(t, "route change after stop", func() bool { return vh.Routing()[2] == 0 })
+}
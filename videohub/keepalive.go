@@ -0,0 +1,63 @@
+package videohub
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Connected reports whether the connection to the device is currently up.
+func (vh *Videohub) Connected() bool {
+	return vh.connectionState() == StateConnected
+}
+
+// LastSeen returns when anything was last received from the device, or the
+// zero time if nothing has been.
+func (vh *Videohub) LastSeen() time.Time {
+	n := vh.lastSeen.Load()
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+func (vh *Videohub) touch() {
+	vh.lastSeen.Store(time.Now().UnixNano())
+}
+
+// Ping sends the protocol's PING keepalive and waits up to the command
+// timeout for the device to acknowledge it.
+func (vh *Videohub) Ping() error {
+	return vh.sendSync(buildBlock("PING", nil))
+}
+
+// keepalive pings the device whenever nothing has been received for the
+// keepalive interval. A ping that is not answered within another interval
+// means the link is dead: the connection is dropped so that the reader
+// reconnects, instead of waiting for the next command to fail.
+func (vh *Videohub) keepalive() {
+	ticker := time.NewTicker(vh.keepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-vh.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !vh.Connected() || time.Since(vh.LastSeen()) < vh.keepaliveInterval {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(vh.ctx, vh.keepaliveInterval)
+		err := vh.sendContext(ctx, buildBlock("PING", nil))
+		cancel()
+		// Other errors mean the connection already failed, which the reader
+		// handles itself.
+		if !errors.Is(err, ErrTimeout) || vh.closed() {
+			continue
+		}
+		lastSeen := vh.LastSeen()
+		vh.logger.Warn("Keepalive failed, dropping connection", "error", err, "last_seen", lastSeen)
+		vh.publish(KeepaliveFailed{LastSeen: lastSeen, Err: err})
+		vh.currentConn().Close()
+	}
+}
@@ -0,0 +1,76 @@
+package videohub
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// blockingDial never connects, so a Videohub that lost its connection stays
+// reconnecting until closed.
+func blockingDial(ctx context.Context, addr string) (net.Conn, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestPing(t *testing.T) {
+	vh, device, commands := readyHub(t)
+	result := make(chan error, 1)
+	go func() { result <- vh.Ping() }()
+	if got, want := nextCommand(t, commands), "PING:\n\n"; got != want {
+		t.Errorf("Ping sent %q, want %q", got, want)
+	}
+	device.Write([]byte("ACK\n"))
+	assertResult(t, result, "Ping", nil)
+}
+
+func TestKeepaliveFailed(t *testing.T) {
+	vh, device := pipeHub(t, WithKeepalive(20*time.Millisecond), WithDialer(blockingDial))
+	writeChunks(device, testDump{4, 4}.text("\n"), 4096)
+	waitReady(t, vh)
+	events, cancel := vh.Subscribe()
+	defer cancel()
+	// The device reads everything but answers nothing from now on.
+	commands := readCommands(device)
+
+	if got, want := nextCommand(t, commands), "PING:\n\n"; got != want {
+		t.Errorf("keepalive sent %q, want %q", got, want)
+	}
+	lastSeen := vh.LastSeen()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case ev := <-events:
+			failed, ok := ev.(KeepaliveFailed)
+			if !ok {
+				continue
+			}
+			if !errors.Is(failed.Err, ErrTimeout) || !failed.LastSeen.Equal(lastSeen) {
+				t.Errorf("KeepaliveFailed = %+v, want ErrTimeout and last seen %v", failed, lastSeen)
+			}
+			eventually(t, "stale state", func() bool { return vh.Stale() && !vh.Connected() })
+			if state := vh.connectionState(); state != StateReconnecting {
+				t.Errorf("state %v after a failed keepalive, want reconnecting", state)
+			}
+			return
+		case <-timeout:
+			t.Fatal("no KeepaliveFailed from a device that stopped answering")
+		}
+	}
+}
+
+func TestKeepaliveAnswered(t *testing.T) {
+	vh, device := pipeHub(t, WithKeepalive(20*time.Millisecond))
+	writeChunks(device, testDump{4, 4}.text("\n"), 4096)
+	waitReady(t, vh)
+	commands := readCommands(device)
+	for i := 0; i < 3; i++ {
+		nextCommand(t, commands)
+		device.Write([]byte("ACK\n"))
+	}
+	if !vh.Connected() || vh.Stale() {
+		t.Errorf("Connected() = %v, Stale() = %v with answered keepalives", vh.Connected(), vh.Stale())
+	}
+}
@@ -0,0 +1,148 @@
+package videohub
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Lock states as used in the VIDEO OUTPUT LOCKS block. In blocks received from
+// the device, LockOwned means this client holds the lock and LockLocked means
+// another client does. UnlockOutput only releases locks held by this client;
+// ForceUnlockOutput sends LockForce to release a lock held by anyone, if
+// allowed with WithAllowForceUnlock.
+const (
+	LockUnlocked = "U"
+	LockOwned    = "O"
+	LockLocked   = "L"
+	LockForce    = "F"
+)
+
+// ErrOutputLocked is returned when routing to an output locked by another
+// client.
+var ErrOutputLocked = errors.New("videohub: output is locked by another client")
+
+// ErrForceUnlockDisabled is returned by ForceUnlockOutput unless the Videohub
+// was created with WithAllowForceUnlock(true).
+var ErrForceUnlockDisabled = errors.New("videohub: force unlock is disabled")
+
+// WithAllowForceUnlock enables ForceUnlockOutput. It is off by default so
+// that, on routers shared between controllers, one client cannot
+// accidentally break the locks of another.
+func WithAllowForceUnlock(allow bool) Option {
+	return func(vh *Videohub) {
+		vh.allowForceUnlock = allow
+	}
+}
+
+// Locks returns a copy of the lock state of each output, one of LockUnlocked,
+// LockOwned or LockLocked.
+func (vh *Videohub) Locks() []string {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return append([]string(nil), vh.locks...)
+}
+
+// LockOutput locks destination on behalf of this client so that other
+// clients cannot change its route.
+func (vh *Videohub) LockOutput(destination int) error {
+	if err := vh.checkOutput(destination); err != nil {
+		return err
+	}
+	return vh.sendBuilt(BuildLockOutputCommand(destination))
+}
+
+// UnlockOutput releases a lock on destination held by this client.
+func (vh *Videohub) UnlockOutput(destination int) error {
+	if err := vh.checkOutput(destination); err != nil {
+		return err
+	}
+	return vh.sendBuilt(BuildUnlockOutputCommand(destination))
+}
+
+// ForceUnlockOutput releases the lock on destination even if another client
+// holds it. It returns ErrForceUnlockDisabled unless enabled with
+// WithAllowForceUnlock. Every use is logged as a warning and, once the device
+// has acknowledged it, published as a ForcedUnlock event.
+func (vh *Videohub) ForceUnlockOutput(destination int) error {
+	if !vh.allowForceUnlock {
+		return ErrForceUnlockDisabled
+	}
+	if err := vh.checkOutput(destination); err != nil {
+		return err
+	}
+	previous := vh.LockState(destination)
+	vh.logger.Warn("Force unlocking output", "output", destination, "lock", previous)
+	if err := vh.sendBuilt(BuildForceUnlockOutputCommand(destination)); err != nil {
+		return err
+	}
+	vh.publish(ForcedUnlock{Destination: destination, Previous: previous, Time: time.Now()})
+	return nil
+}
+
+// LockState returns the lock state of output destination, or LockUnlocked if
+// it is out of range.
+func (vh *Videohub) LockState(destination int) string {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	if destination < 0 || destination >= len(vh.locks) {
+		return LockUnlocked
+	}
+	return vh.locks[destination]
+}
+
+// checkUnlocked returns ErrOutputLocked if another client holds the lock on
+// destination.
+func (vh *Videohub) checkUnlocked(destination int) error {
+	if vh.LockState(destination) == LockLocked {
+		return fmt.Errorf("%w: output %d", ErrOutputLocked, destination)
+	}
+	return nil
+}
+
+func (vh *Videohub) processOutputLocks(contents []string) {
+	vh.mu.Lock()
+	seen := vh.locksSeen
+	vh.locksSeen = true
+	changes := updateLocks(&vh.locks, vh.portLimit(vh.outputs), contents)
+	vh.mu.Unlock()
+	if seen {
+		for _, c := range changes {
+			vh.publish(c)
+		}
+	}
+}
+
+// updateLocks applies the lines of a locks block to locks, ignoring malformed
+// lines and ports from limit on, and returns the ports whose state changed.
+func updateLocks(locks *[]string, limit int, contents []string) []LockChange {
+	var changes []LockChange
+	for _, item := range contents {
+		parts := strings.Split(item, " ")
+		if len(parts) == 2 {
+			i, ok := parseIndex(parts[0])
+			if !ok || i >= limit {
+				continue
+			}
+			switch parts[1] {
+			case LockUnlocked, LockOwned, LockLocked:
+				*locks = growLocks(*locks, i+1)
+				if old := (*locks)[i]; old != parts[1] {
+					changes = append(changes, LockChange{Destination: i, State: parts[1], Previous: old, Time: time.Now()})
+				}
+				(*locks)[i] = parts[1]
+			}
+		}
+	}
+	return changes
+}
+
+// growLocks returns locks extended to at least n entries, keeping the
+// existing ones and marking new outputs as unlocked.
+func growLocks(locks []string, n int) []string {
+	for len(locks) < n {
+		locks = append(locks, LockUnlocked)
+	}
+	return locks
+}
@@ -0,0 +1,149 @@
+package videohub
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// InputIndex returns the input whose label matches label, ignoring case and
+// surrounding whitespace. ok is false if no input or more than one input
+// matches.
+func (vh *Videohub) InputIndex(label string) (int, bool) {
+	matches := vh.matchLabel(LabelInput, label, matchConfig{})
+	if len(matches) != 1 {
+		return -1, false
+	}
+	return matches[0], true
+}
+
+// OutputIndex returns the output whose label matches label, ignoring case and
+// surrounding whitespace. ok is false if no output or more than one output
+// matches.
+func (vh *Videohub) OutputIndex(label string) (int, bool) {
+	matches := vh.matchLabel(LabelOutput, label, matchConfig{})
+	if len(matches) != 1 {
+		return -1, false
+	}
+	return matches[0], true
+}
+
+// RouteByLabel routes the input labelled sourceLabel to the output labelled
+// destinationLabel. Labels are matched as in InputIndex and OutputIndex; it is
+// an error for either label to match no port or several ports.
+func (vh *Videohub) RouteByLabel(destinationLabel, sourceLabel string) error {
+	return vh.RouteByName(destinationLabel, sourceLabel)
+}
+
+type matchConfig struct {
+	prefix bool
+	fuzzy  bool
+}
+
+// MatchOption loosens how InputIndexByLabel, OutputIndexByLabel and
+// RouteByName match labels. A looser match is only tried when the stricter
+// ones find nothing, so an exact label always wins.
+type MatchOption func(*matchConfig)
+
+// WithPrefixMatch lets a name match labels that start with it, so "CAM"
+// finds "CAM 3" if no label is exactly "CAM".
+func WithPrefixMatch() MatchOption {
+	return func(c *matchConfig) {
+		c.prefix = true
+	}
+}
+
+// WithFuzzyMatch lets a name match labels that contain it once spaces and
+// punctuation are ignored, so "cam3" finds "Cam-3 (ISO)".
+func WithFuzzyMatch() MatchOption {
+	return func(c *matchConfig) {
+		c.fuzzy = true
+	}
+}
+
+// InputIndexByLabel returns the input whose label matches label, ignoring case
+// and surrounding whitespace, and loosened by opts. It is an error for the
+// label to match no input or several inputs.
+func (vh *Videohub) InputIndexByLabel(label string, opts ...MatchOption) (int, error) {
+	return vh.resolveLabel(LabelInput, label, opts...)
+}
+
+// OutputIndexByLabel returns the output whose label matches label, as with
+// InputIndexByLabel.
+func (vh *Videohub) OutputIndexByLabel(label string, opts ...MatchOption) (int, error) {
+	return vh.resolveLabel(LabelOutput, label, opts...)
+}
+
+// RouteByName routes the input named sourceLabel to the output named
+// destinationLabel, matching both as in InputIndexByLabel.
+func (vh *Videohub) RouteByName(destinationLabel, sourceLabel string, opts ...MatchOption) error {
+	destination, err := vh.resolveLabel(LabelOutput, destinationLabel, opts...)
+	if err != nil {
+		return err
+	}
+	source, err := vh.resolveLabel(LabelInput, sourceLabel, opts...)
+	if err != nil {
+		return err
+	}
+	return vh.Route(destination, source)
+}
+
+func (vh *Videohub) resolveLabel(kind LabelKind, label string, opts ...MatchOption) (int, error) {
+	var cfg matchConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	matches := vh.matchLabel(kind, label, cfg)
+	switch len(matches) {
+	case 0:
+		return -1, fmt.Errorf("videohub: no %s labelled %q", kind, label)
+	case 1:
+		return matches[0], nil
+	}
+	return -1, fmt.Errorf("videohub: %s label %q is ambiguous, matches %ss %v", kind, label, kind, matches)
+}
+
+// matchLabel returns the ports whose label matches label by the strictest
+// rule allowed by cfg that matches at all.
+func (vh *Videohub) matchLabel(kind LabelKind, label string, cfg matchConfig) []int {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	labels := vh.inputLabels
+	if kind == LabelOutput {
+		labels = vh.outputLabels
+	}
+	want := strings.ToLower(strings.TrimSpace(label))
+	rules := []func(l string) bool{
+		func(l string) bool { return l == want },
+	}
+	if cfg.prefix {
+		rules = append(rules, func(l string) bool { return strings.HasPrefix(l, want) })
+	}
+	if cfg.fuzzy {
+		if want := normalizeLabel(want); want != "" {
+			rules = append(rules, func(l string) bool { return strings.Contains(normalizeLabel(l), want) })
+		}
+	}
+	for _, rule := range rules {
+		var matches []int
+		for i, l := range labels {
+			if rule(strings.ToLower(strings.TrimSpace(l))) {
+				matches = append(matches, i)
+			}
+		}
+		if len(matches) > 0 {
+			return matches
+		}
+	}
+	return nil
+}
+
+// normalizeLabel keeps only the letters and digits of a lower-cased label.
+func normalizeLabel(label string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, label)
+}
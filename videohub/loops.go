@@ -0,0 +1,99 @@
+package videohub
+
+import (
+	"fmt"
+	"sort"
+)
+
+// HubLink is a tie-line: a cable from an output of one device to an input of
+// another (or the same) device, with devices given by unique ID.
+type HubLink struct {
+	FromDevice string `json:"fromDevice"`
+	Output     int    `json:"output"`
+	ToDevice   string `json:"toDevice"`
+	Input      int    `json:"input"`
+}
+
+// DetectLoops reports the video loops formed by the current routing of the
+// fleet's devices together with links. See DetectLoops for the format.
+func (f *Fleet) DetectLoops(links []HubLink) [][]string {
+	f.mu.RLock()
+	snapshots := make(map[string]Snapshot, len(f.hubs))
+	for id, m := range f.hubs {
+		snapshots[id] = m.vh.Snapshot()
+	}
+	f.mu.RUnlock()
+	return DetectLoops(snapshots, links)
+}
+
+// portRef is one output of one device.
+type portRef struct {
+	device string
+	output int
+}
+
+// DetectLoops walks the routing in snapshots, keyed by unique ID, across the
+// tie-lines in links and returns every loop in which a signal is fed back to
+// an output it already passed through. Each loop lists its outputs in signal
+// order as "device/output" addresses, starting with the one that sorts
+// first; loops are sorted by that output. Links to devices missing from
+// snapshots and unknown routes are ignored.
+func DetectLoops(snapshots map[string]Snapshot, links []HubLink) [][]string {
+	// An output leads to every output of the linked device that is routed
+	// from the input at the other end of the cable.
+	next := make(map[portRef][]portRef)
+	for _, link := range links {
+		snap, ok := snapshots[link.ToDevice]
+		if !ok {
+			continue
+		}
+		from := portRef{link.FromDevice, link.Output}
+		for output, source := range snap.Routing {
+			if source == link.Input {
+				next[from] = append(next[from], portRef{link.ToDevice, output})
+			}
+		}
+	}
+
+	nodes := make([]portRef, 0, len(next))
+	for node := range next {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return portLess(nodes[i], nodes[j]) })
+
+	// Each loop is found once, from its first output: the search from start
+	// only passes through outputs that sort after it.
+	var loops [][]string
+	for _, start := range nodes {
+		var path []portRef
+		onPath := make(map[portRef]bool)
+		var walk func(node portRef)
+		walk = func(node portRef) {
+			path = append(path, node)
+			onPath[node] = true
+			for _, n := range next[node] {
+				switch {
+				case n == start:
+					loop := make([]string, len(path))
+					for i, p := range path {
+						loop[i] = fmt.Sprintf("%s/%d", p.device, p.output)
+					}
+					loops = append(loops, loop)
+				case portLess(start, n) && !onPath[n]:
+					walk(n)
+				}
+			}
+			path = path[:len(path)-1]
+			delete(onPath, node)
+		}
+		walk(start)
+	}
+	return loops
+}
+
+func portLess(a, b portRef) bool {
+	if a.device != b.device {
+		return a.device < b.device
+	}
+	return a.output < b.output
+}
@@ -0,0 +1,70 @@
+package videohub
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDetectLoops(t *testing.T) {
+	// Hub A output 0 feeds hub B input 1, and hub B output 2 feeds hub A
+	// input 3.
+	tieLines := []HubLink{
+		{FromDevice: "A", Output: 0, ToDevice: "B", Input: 1},
+		{FromDevice: "B", Output: 2, ToDevice: "A", Input: 3},
+	}
+	tests := []struct {
+		name      string
+		snapshots map[string]Snapshot
+		links     []HubLink
+		want      [][]string
+	}{
+		{
+			name: "two-hub cycle",
+			snapshots: map[string]Snapshot{
+				"A": {Routing: []int{3, 1, 2, 3}},
+				"B": {Routing: []int{0, 0, 1, 1}},
+			},
+			links: tieLines,
+			want:  [][]string{{"A/0", "B/2"}},
+		},
+		{
+			name: "acyclic",
+			snapshots: map[string]Snapshot{
+				"A": {Routing: []int{0, 1, 2, 3}},
+				"B": {Routing: []int{0, 0, 1, 1}},
+			},
+			links: tieLines,
+		},
+		{
+			name: "both paths back through the same output",
+			snapshots: map[string]Snapshot{
+				"A": {Routing: []int{3, 1, 2, 3}},
+				"B": {Routing: []int{0, 0, 1, 1}},
+			},
+			links: append(tieLines, HubLink{FromDevice: "B", Output: 3, ToDevice: "A", Input: 3}),
+			want:  [][]string{{"A/0", "B/2"}, {"A/0", "B/3"}},
+		},
+		{
+			name: "output looped into its own device",
+			snapshots: map[string]Snapshot{
+				"A": {Routing: []int{-1, 4, 2}},
+			},
+			links: []HubLink{{FromDevice: "A", Output: 1, ToDevice: "A", Input: 4}},
+			want:  [][]string{{"A/1"}},
+		},
+		{
+			name: "link to an unknown device",
+			snapshots: map[string]Snapshot{
+				"A": {Routing: []int{3, 1, 2, 3}},
+			},
+			links: tieLines,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectLoops(tt.snapshots, tt.links); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DetectLoops() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package videohub
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// commandBuckets are the upper bounds, in seconds, of the command latency
+// histogram.
+var commandBuckets = [...]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// metrics holds the counters behind Metrics. Every field is updated
+// atomically so instrumenting the hot paths needs no lock.
+type metrics struct {
+	crosspointChanges atomic.Uint64
+	reconnects        atomic.Uint64
+	acks              atomic.Uint64
+	naks              atomic.Uint64
+	timeouts          atomic.Uint64
+	commands          atomic.Uint64
+	commandNanos      atomic.Int64
+	commandBuckets    [len(commandBuckets) + 1]atomic.Uint64 // One per commandBuckets entry, then +Inf
+}
+
+func (m *metrics) observeCommand(d time.Duration) {
+	m.commands.Add(1)
+	m.commandNanos.Add(int64(d))
+	i := 0
+	for i < len(commandBuckets) && d.Seconds() > commandBuckets[i] {
+		i++
+	}
+	m.commandBuckets[i].Add(1)
+}
+
+// Metrics is a point-in-time copy of the counters kept by a Videohub.
+type Metrics struct {
+	Connected         bool          `json:"connected"`
+	CrosspointChanges uint64        `json:"crosspointChanges"` // Route changes reported by the device
+	Reconnects        uint64        `json:"reconnects"`        // Successful reconnects after a dropped connection
+	ACKs              uint64        `json:"acks"`
+	NAKs              uint64        `json:"naks"`
+	Timeouts          uint64        `json:"timeouts"` // Commands not answered within their deadline
+	Commands          uint64        `json:"commands"` // Commands answered by the device
+	CommandTime       time.Duration `json:"commandTime"`
+}
+
+// Metrics returns the current counters.
+func (vh *Videohub) Metrics() Metrics {
+	m := &vh.metrics
+	return Metrics{
+		Connected:         vh.connectionState() == StateConnected,
+		CrosspointChanges: m.crosspointChanges.Load(),
+		Reconnects:        m.reconnects.Load(),
+		ACKs:              m.acks.Load(),
+		NAKs:              m.naks.Load(),
+		Timeouts:          m.timeouts.Load(),
+		Commands:          m.commands.Load(),
+		CommandTime:       time.Duration(m.commandNanos.Load()),
+	}
+}
+
+// MetricsHandler returns an http.Handler serving the metrics of vh in the
+// Prometheus text exposition format, for scraping without a client library.
+func (vh *Videohub) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		vh.WriteMetrics(w)
+	})
+}
+
+// WriteMetrics writes the metrics of vh in the Prometheus text exposition
+// format. Every series carries a device label with the unique ID.
+func (vh *Videohub) WriteMetrics(w io.Writer) error {
+	m := &vh.metrics
+	snap := vh.Metrics()
+	device := fmt.Sprintf(`device="%s"`, escapeLabel(vh.UniqueID()))
+
+	var b strings.Builder
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	metric("videohub_connected", "gauge", "Whether the connection to the device is up.")
+	fmt.Fprintf(&b, "videohub_connected{%s} %d\n", device, boolMetric(snap.Connected))
+	metric("videohub_crosspoint_changes_total", "counter", "Route changes reported by the device.")
+	fmt.Fprintf(&b, "videohub_crosspoint_changes_total{%s} %d\n", device, snap.CrosspointChanges)
+	metric("videohub_reconnects_total", "counter", "Successful reconnects after a dropped connection.")
+	fmt.Fprintf(&b, "videohub_reconnects_total{%s} %d\n", device, snap.Reconnects)
+	metric("videohub_responses_total", "counter", "Command responses by result.")
+	fmt.Fprintf(&b, "videohub_responses_total{%s,result=\"ack\"} %d\n", device, snap.ACKs)
+	fmt.Fprintf(&b, "videohub_responses_total{%s,result=\"nak\"} %d\n", device, snap.NAKs)
+	fmt.Fprintf(&b, "videohub_responses_total{%s,result=\"timeout\"} %d\n", device, snap.Timeouts)
+
+	metric("videohub_command_duration_seconds", "histogram", "Time from sending a command to its last response.")
+	var cumulative uint64
+	for i, le := range commandBuckets {
+		cumulative += m.commandBuckets[i].Load()
+		fmt.Fprintf(&b, "videohub_command_duration_seconds_bucket{%s,le=\"%s\"} %d\n", device, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+	}
+	cumulative += m.commandBuckets[len(commandBuckets)].Load()
+	fmt.Fprintf(&b, "videohub_command_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", device, cumulative)
+	fmt.Fprintf(&b, "videohub_command_duration_seconds_sum{%s} %g\n", device, snap.CommandTime.Seconds())
+	fmt.Fprintf(&b, "videohub_command_duration_seconds_count{%s} %d\n", device, cumulative)
+
+	metric("videohub_output_source", "gauge", "Input currently routed to each output, -1 if unknown.")
+	labels := vh.OutputLabels()
+	for o, source := range vh.Routing() {
+		label := ""
+		if o < len(labels) {
+			label = labels[o]
+		}
+		fmt.Fprintf(&b, "videohub_output_source{%s,output=\"%d\",output_label=\"%s\"} %d\n", device, o, escapeLabel(label), source)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func boolMetric(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// labelEscaper escapes the characters the exposition format requires to be
+// escaped in label values: backslashes, double quotes and line feeds.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel prepares s to be written between the quotes of a label value.
+// Everything else is written as is, as UTF-8, which must be valid.
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(strings.ToValidUTF8(s, "\uFFFD"))
+}
@@ -0,0 +1,74 @@
+package videohub
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	vh, device := pipeHub(t)
+	writeChunks(device, testDump{2, 2}.text("\n"), 4096)
+	waitReady(t, vh)
+	device.Write([]byte("OUTPUT LABELS:\n1 \"Caméra\"\u00a0\\ 2\n\n"))
+	eventually(t, "output label", func() bool { return vh.OutputLabels()[1] != "Monitor 2" })
+
+	var b strings.Builder
+	if err := vh.WriteMetrics(&b); err != nil {
+		t.Fatal(err)
+	}
+	// Label values are UTF-8 with only \\, \" and \n escaped, so the no-break
+	// space in the label is written as is, not as \u00a0.
+	want := `# HELP videohub_connected Whether the connection to the device is up.
+# TYPE videohub_connected gauge
+videohub_connected{device="7C2E0DA4BFC0"} 1
+# HELP videohub_crosspoint_changes_total Route changes reported by the device.
+# TYPE videohub_crosspoint_changes_total counter
+videohub_crosspoint_changes_total{device="7C2E0DA4BFC0"} 0
+# HELP videohub_reconnects_total Successful reconnects after a dropped connection.
+# TYPE videohub_reconnects_total counter
+videohub_reconnects_total{device="7C2E0DA4BFC0"} 0
+# HELP videohub_responses_total Command responses by result.
+# TYPE videohub_responses_total counter
+videohub_responses_total{device="7C2E0DA4BFC0",result="ack"} 0
+videohub_responses_total{device="7C2E0DA4BFC0",result="nak"} 0
+videohub_responses_total{device="7C2E0DA4BFC0",result="timeout"} 0
+# HELP videohub_command_duration_seconds Time from sending a command to its last response.
+# TYPE videohub_command_duration_seconds histogram
+videohub_command_duration_seconds_bucket{device="7C2E0DA4BFC0",le="0.005"} 0
+videohub_command_duration_seconds_bucket{device="7C2E0DA4BFC0",le="0.01"} 0
+videohub_command_duration_seconds_bucket{device="7C2E0DA4BFC0",le="0.025"} 0
+videohub_command_duration_seconds_bucket{device="7C2E0DA4BFC0",le="0.05"} 0
+videohub_command_duration_seconds_bucket{device="7C2E0DA4BFC0",le="0.1"} 0
+videohub_command_duration_seconds_bucket{device="7C2E0DA4BFC0",le="0.25"} 0
+videohub_command_duration_seconds_bucket{device="7C2E0DA4BFC0",le="0.5"} 0
+videohub_command_duration_seconds_bucket{device="7C2E0DA4BFC0",le="1"} 0
+videohub_command_duration_seconds_bucket{device="7C2E0DA4BFC0",le="2.5"} 0
+videohub_command_duration_seconds_bucket{device="7C2E0DA4BFC0",le="5"} 0
+videohub_command_duration_seconds_bucket{device="7C2E0DA4BFC0",le="+Inf"} 0
+videohub_command_duration_seconds_sum{device="7C2E0DA4BFC0"} 0
+videohub_command_duration_seconds_count{device="7C2E0DA4BFC0"} 0
+# HELP videohub_output_source Input currently routed to each output, -1 if unknown.
+# TYPE videohub_output_source gauge
+videohub_output_source{device="7C2E0DA4BFC0",output="0",output_label="Monitor 1"} 1
+videohub_output_source{device="7C2E0DA4BFC0",output="1",output_label="\"Caméra\" \\ 2"} 0
+`
+	if got := b.String(); got != want {
+		t.Errorf("WriteMetrics wrote\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	for in, want := range map[string]string{
+		"Camera 1":      "Camera 1",
+		`a\b`:           `a\\b`,
+		`say "hi"`:      `say \"hi\"`,
+		"two\nlines":    `two\nlines`,
+		"tab\there":     "tab\there",
+		"Caméra\u00a0½": "Caméra\u00a0½",
+		"bad\xffbyte":   "bad\ufffdbyte",
+	} {
+		if got := escapeLabel(in); got != want {
+			t.Errorf("escapeLabel(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
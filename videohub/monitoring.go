@@ -0,0 +1,71 @@
+package videohub
+
+// MonitoringOutputs returns the number of monitoring outputs, or 0 on devices
+// without them.
+func (vh *Videohub) MonitoringOutputs() int {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return vh.monitorOutputs
+}
+
+// SerialPorts returns the number of RS-422 serial ports, or 0 on devices
+// without them.
+func (vh *Videohub) SerialPorts() int {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return vh.serialPorts
+}
+
+// MonitoringOutputLabels returns a copy of the monitoring output labels.
+func (vh *Videohub) MonitoringOutputLabels() []string {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return append([]string(nil), vh.monitorLabels...)
+}
+
+// MonitoringRouting returns a copy of the input routed to each monitoring
+// output, or -1 if not yet known.
+func (vh *Videohub) MonitoringRouting() []int {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return append([]int(nil), vh.monitorRouting...)
+}
+
+// MonitorRoute routes source to the monitoring output destination.
+func (vh *Videohub) MonitorRoute(destination, source int) error {
+	if err := vh.checkMonitoringOutput(destination); err != nil {
+		return err
+	}
+	if err := vh.checkInput(source); err != nil {
+		return err
+	}
+	return vh.sendBuilt(BuildMonitoringRouteCommand(destination, source))
+}
+
+// MonitorOutputLabel sets the label of the monitoring output destination.
+func (vh *Videohub) MonitorOutputLabel(destination int, label string) error {
+	if err := vh.checkMonitoringOutput(destination); err != nil {
+		return err
+	}
+	return vh.sendBuilt(buildLabelCommand(string(BlockMonitoringOutputLabels), "monitoring output", destination, label, vh.labelCharset))
+}
+
+// RouteMonitoring is the same as MonitorRoute.
+//
+// Deprecated: Use MonitorRoute.
+func (vh *Videohub) RouteMonitoring(destination, source int) error {
+	return vh.MonitorRoute(destination, source)
+}
+
+// MonitoringOutputLabel is the same as MonitorOutputLabel.
+//
+// Deprecated: Use MonitorOutputLabel.
+func (vh *Videohub) MonitoringOutputLabel(destination int, label string) error {
+	return vh.MonitorOutputLabel(destination, label)
+}
+
+func (vh *Videohub) processMonitoringRouting(contents []string) {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	updateRouting(&vh.monitorRouting, vh.portLimit(vh.monitorOutputs), contents)
+}
@@ -0,0 +1,369 @@
+// Package mqtt bridges a Videohub to an MQTT broker, so that home and
+// broadcast automation systems can follow and change its routing.
+//
+// Topics are below <prefix>/<unique ID>, with zero-based port numbers:
+//
+//	availability               "online" or "offline"
+//	output/<n>/source          Input routed to output n
+//	output/<n>/source_label    Label of that input
+//	output/<n>/label           Label of output n
+//	output/<n>/lock            "unlocked", "owned" or "locked"
+//	input/<n>/label            Label of input n
+//
+// All of them are retained. Each has a command topic with /set appended:
+// publishing an input index to output/<n>/source/set or an input label to
+// output/<n>/source_label/set routes that input to output n, and publishing
+// to output/<n>/label/set or input/<n>/label/set relabels the port. Indexes
+// and labels have separate topics so that inputs labelled "1", "2" and so on
+// are not mistaken for indexes.
+//
+// With Home Assistant discovery enabled, each output also appears as a
+// select entity listing the input labels.
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StechLabs/pydeohub/videohub"
+)
+
+const (
+	// DefaultTopicPrefix is the first topic level used when Config has none.
+	DefaultTopicPrefix = "videohub"
+	// DefaultDiscoveryPrefix is the Home Assistant discovery prefix.
+	DefaultDiscoveryPrefix = "homeassistant"
+	// DefaultKeepAlive is the MQTT keepalive used when Config has none.
+	DefaultKeepAlive = 30 * time.Second
+)
+
+// ErrPasswordWithoutUsername is returned by Run when Config has a Password
+// but no Username; MQTT 3.1.1 cannot send one without the other.
+var ErrPasswordWithoutUsername = errors.New("mqtt: Password set without Username")
+
+// Config configures a Bridge.
+type Config struct {
+	Broker          string // Broker address (ex. '192.168.0.10:1883')
+	ClientID        string // Defaults to 'videohub-<unique ID>'
+	Username        string
+	Password        string
+	TopicPrefix     string // Defaults to DefaultTopicPrefix
+	Discovery       bool   // Publish Home Assistant discovery payloads
+	DiscoveryPrefix string // Defaults to DefaultDiscoveryPrefix
+	KeepAlive       time.Duration
+	Logger          *slog.Logger // Defaults to slog.Default()
+}
+
+// Bridge publishes the state of a Videohub to an MQTT broker and applies
+// commands received from it.
+type Bridge struct {
+	vh     *videohub.Videohub
+	cfg    Config
+	logger *slog.Logger
+	client *client
+	base   string // Topic prefix for this device
+}
+
+// New returns a Bridge between vh and the broker in cfg. The caller keeps
+// ownership of vh.
+func New(vh *videohub.Videohub, cfg Config) *Bridge {
+	if cfg.TopicPrefix == "" {
+		cfg.TopicPrefix = DefaultTopicPrefix
+	}
+	if cfg.DiscoveryPrefix == "" {
+		cfg.DiscoveryPrefix = DefaultDiscoveryPrefix
+	}
+	if cfg.KeepAlive == 0 {
+		cfg.KeepAlive = DefaultKeepAlive
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Bridge{vh: vh, cfg: cfg, logger: logger.With("mqtt", cfg.Broker)}
+}
+
+// Run waits for the Videohub to be ready, connects to the broker, publishes
+// the current state and then keeps the broker up to date until ctx is done.
+// It returns when the broker connection is lost, the Videohub is closed or a
+// different device answers at its address; call it again to reconnect.
+func (b *Bridge) Run(ctx context.Context) error {
+	if b.cfg.Password != "" && b.cfg.Username == "" {
+		return ErrPasswordWithoutUsername
+	}
+	if err := b.vh.WaitReady(ctx); err != nil {
+		return err
+	}
+	id := b.vh.UniqueID()
+	b.base = b.cfg.TopicPrefix + "/" + id
+	clientID := b.cfg.ClientID
+	if clientID == "" {
+		clientID = "videohub-" + id
+	}
+	events, unsubscribe := b.vh.Subscribe()
+	defer unsubscribe()
+
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	c, err := dialMQTT(dialCtx, b.cfg.Broker, clientID, b.cfg.Username, b.cfg.Password, b.cfg.KeepAlive,
+		&will{topic: b.topic("availability"), payload: []byte("offline"), retain: true})
+	cancel()
+	if err != nil {
+		return err
+	}
+	b.client = c
+	defer c.close()
+	b.logger.Info("Connected to broker", "topic", b.base)
+
+	if err := b.publishAll(); err != nil {
+		return err
+	}
+	for _, filter := range []string{"output/+/source/set", "output/+/source_label/set", "output/+/label/set", "input/+/label/set"} {
+		if err := c.subscribe(b.topic(filter)); err != nil {
+			return err
+		}
+	}
+
+	// Commands wait for the device to answer, so they run on their own
+	// goroutine, in the order they arrived, to keep both streams moving.
+	commands := make(chan [2]string, 16)
+	runCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	clientErr := make(chan error, 1)
+	go func() {
+		clientErr <- c.run(runCtx, func(topic string, payload []byte) {
+			select {
+			case commands <- [2]string{topic, string(payload)}:
+			default:
+				b.logger.Warn("Dropping MQTT command, too many pending", "topic", topic)
+			}
+		})
+	}()
+	go func() {
+		for {
+			select {
+			case <-runCtx.Done():
+				return
+			case cmd := <-commands:
+				if err := b.handleCommand(cmd[0], cmd[1]); err != nil {
+					b.logger.Warn("MQTT command failed", "topic", cmd[0], "payload", cmd[1], "error", err)
+				}
+			}
+		}
+	}()
+
+	for {
+		select {
+		case err := <-clientErr:
+			return err
+		case ev, ok := <-events:
+			if !ok {
+				return videohub.ErrClosed
+			}
+			if _, changed := ev.(videohub.DeviceChange); changed {
+				return errors.New("mqtt: a different device now answers at the Videohub address")
+			}
+			if err := b.publishEvent(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (b *Bridge) topic(suffix string) string {
+	return b.base + "/" + suffix
+}
+
+func (b *Bridge) publish(suffix, payload string) error {
+	return b.client.publish(b.topic(suffix), []byte(payload), true)
+}
+
+// publishAll publishes the whole state, and the discovery payloads if
+// enabled.
+func (b *Bridge) publishAll() error {
+	if err := b.publish("availability", availability(b.vh.Connected())); err != nil {
+		return err
+	}
+	inputs, outputs, routing, locks := b.vh.InputLabels(), b.vh.OutputLabels(), b.vh.Routing(), b.vh.Locks()
+	for i, label := range inputs {
+		if err := b.publish(fmt.Sprintf("input/%d/label", i), label); err != nil {
+			return err
+		}
+	}
+	for o, label := range outputs {
+		if err := b.publish(fmt.Sprintf("output/%d/label", o), label); err != nil {
+			return err
+		}
+	}
+	for o, source := range routing {
+		if err := b.publishSource(o, source, inputs); err != nil {
+			return err
+		}
+	}
+	for o, state := range locks {
+		if err := b.publish(fmt.Sprintf("output/%d/lock", o), lockState(state)); err != nil {
+			return err
+		}
+	}
+	return b.publishDiscovery()
+}
+
+func (b *Bridge) publishSource(output, source int, inputs []string) error {
+	if source < 0 {
+		return nil
+	}
+	if err := b.publish(fmt.Sprintf("output/%d/source", output), strconv.Itoa(source)); err != nil {
+		return err
+	}
+	return b.publish(fmt.Sprintf("output/%d/source_label", output), label(inputs, source))
+}
+
+func (b *Bridge) publishEvent(ev videohub.Event) error {
+	switch ev := ev.(type) {
+	case videohub.RouteChange:
+		return b.publishSource(ev.Destination, ev.Source, b.vh.InputLabels())
+	case videohub.LabelChange:
+		switch ev.Kind {
+		case videohub.LabelInput:
+			if err := b.publish(fmt.Sprintf("input/%d/label", ev.Index), ev.Label); err != nil {
+				return err
+			}
+			for o, source := range b.vh.Routing() {
+				if source == ev.Index {
+					if err := b.publish(fmt.Sprintf("output/%d/source_label", o), ev.Label); err != nil {
+						return err
+					}
+				}
+			}
+			return b.publishDiscovery()
+		case videohub.LabelOutput:
+			if err := b.publish(fmt.Sprintf("output/%d/label", ev.Index), ev.Label); err != nil {
+				return err
+			}
+			return b.publishDiscovery()
+		}
+	case videohub.LockChange:
+		return b.publish(fmt.Sprintf("output/%d/lock", ev.Destination), lockState(ev.State))
+	case videohub.ConnectionChange:
+		return b.publish("availability", availability(ev.New == videohub.StateConnected))
+	case videohub.DimensionsChange:
+		return b.publishAll()
+	}
+	return nil
+}
+
+// discoveryDevice groups the entities of one Videohub in Home Assistant.
+type discoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+// discoverySelect is the discovery payload of an MQTT select entity.
+type discoverySelect struct {
+	Name              string          `json:"name"`
+	UniqueID          string          `json:"unique_id"`
+	StateTopic        string          `json:"state_topic"`
+	CommandTopic      string          `json:"command_topic"`
+	AvailabilityTopic string          `json:"availability_topic"`
+	Options           []string        `json:"options"`
+	Device            discoveryDevice `json:"device"`
+}
+
+// publishDiscovery publishes a select entity for every output. Input labels
+// should be unique for Home Assistant to tell them apart.
+func (b *Bridge) publishDiscovery() error {
+	if !b.cfg.Discovery {
+		return nil
+	}
+	id := b.vh.UniqueID()
+	model := b.vh.Model()
+	device := discoveryDevice{
+		Identifiers:  []string{"videohub_" + id},
+		Name:         model + " " + id,
+		Manufacturer: "Blackmagic Design",
+		Model:        model,
+	}
+	inputs := b.vh.InputLabels()
+	for o, name := range b.vh.OutputLabels() {
+		payload, err := json.Marshal(discoverySelect{
+			Name:              name,
+			UniqueID:          fmt.Sprintf("videohub_%s_output_%d", id, o),
+			StateTopic:        b.topic(fmt.Sprintf("output/%d/source_label", o)),
+			CommandTopic:      b.topic(fmt.Sprintf("output/%d/source_label/set", o)),
+			AvailabilityTopic: b.topic("availability"),
+			Options:           inputs,
+			Device:            device,
+		})
+		if err != nil {
+			return err
+		}
+		topic := fmt.Sprintf("%s/select/videohub_%s/output_%d/config", b.cfg.DiscoveryPrefix, id, o)
+		if err := b.client.publish(topic, payload, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleCommand applies a message received on one of the set topics.
+func (b *Bridge) handleCommand(topic, payload string) error {
+	parts := strings.Split(strings.TrimPrefix(topic, b.base+"/"), "/")
+	if len(parts) != 4 || parts[3] != "set" {
+		return fmt.Errorf("mqtt: unexpected topic %q", topic)
+	}
+	index, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return fmt.Errorf("mqtt: bad port number in topic %q", topic)
+	}
+	payload = strings.TrimSpace(payload)
+	switch parts[0] + "/" + parts[2] {
+	case "output/source":
+		source, err := strconv.Atoi(payload)
+		if err != nil {
+			return fmt.Errorf("mqtt: bad input number %q", payload)
+		}
+		return b.vh.Route(index, source)
+	case "output/source_label":
+		source, err := b.vh.InputIndexByLabel(payload)
+		if err != nil {
+			return err
+		}
+		return b.vh.Route(index, source)
+	case "output/label":
+		return b.vh.OutputLabel(index, payload)
+	case "input/label":
+		return b.vh.InputLabel(index, payload)
+	}
+	return fmt.Errorf("mqtt: unexpected topic %q", topic)
+}
+
+func availability(connected bool) string {
+	if connected {
+		return "online"
+	}
+	return "offline"
+}
+
+func lockState(state string) string {
+	switch state {
+	case videohub.LockOwned:
+		return "owned"
+	case videohub.LockLocked:
+		return "locked"
+	}
+	return "unlocked"
+}
+
+func label(labels []string, i int) string {
+	if i >= 0 && i < len(labels) {
+		return labels[i]
+	}
+	return ""
+}
@@ -0,0 +1,214 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/StechLabs/pydeohub/videohub"
+	"github.com/StechLabs/pydeohub/videohub/simulator"
+)
+
+func TestRunRejectsPasswordWithoutUsername(t *testing.T) {
+	b := New(nil, Config{Broker: "127.0.0.1:1883", Password: "secret"})
+	if err := b.Run(context.Background()); !errors.Is(err, ErrPasswordWithoutUsername) {
+		t.Fatalf("Run = %v, want %v", err, ErrPasswordWithoutUsername)
+	}
+}
+
+// brokerStub is an in-process MQTT broker for a single client: it accepts
+// every connection and subscription and keeps the last payload published to
+// each topic.
+type brokerStub struct {
+	l net.Listener
+
+	mu            sync.Mutex
+	conn          *client
+	retained      map[string]string
+	subscriptions []string
+}
+
+func startBroker(t *testing.T) *brokerStub {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &brokerStub{l: l, retained: make(map[string]string)}
+	t.Cleanup(func() {
+		l.Close()
+		b.mu.Lock()
+		if b.conn != nil {
+			b.conn.conn.Close()
+		}
+		b.mu.Unlock()
+	})
+	go b.serve()
+	return b
+}
+
+func (b *brokerStub) serve() {
+	conn, err := b.l.Accept()
+	if err != nil {
+		return
+	}
+	c := &client{conn: conn, reader: bufio.NewReader(conn)}
+	b.mu.Lock()
+	b.conn = c
+	b.mu.Unlock()
+	for {
+		header, body, err := c.readPacket()
+		if err != nil {
+			return
+		}
+		switch header & 0xF0 {
+		case packetConnect:
+			c.write(packetConnack, []byte{0, 0})
+		case packetPublish:
+			topic, payload, _ := readString(body)
+			b.mu.Lock()
+			b.retained[topic] = string(payload)
+			b.mu.Unlock()
+		case packetSubscribe:
+			filter, _, _ := readString(body[2:])
+			b.mu.Lock()
+			b.subscriptions = append(b.subscriptions, filter)
+			b.mu.Unlock()
+			c.write(packetSuback, []byte{body[0], body[1], 0})
+		case packetPingreq:
+			c.write(packetPingresp, nil)
+		}
+	}
+}
+
+// send publishes payload to topic for the bridge to receive.
+func (b *brokerStub) send(t *testing.T, topic, payload string) {
+	t.Helper()
+	b.mu.Lock()
+	c := b.conn
+	b.mu.Unlock()
+	if err := c.publish(topic, []byte(payload), false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// eventually fails the test if cond does not hold within five seconds.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitPublished waits for payload to be the last message published to
+// topic.
+func (b *brokerStub) waitPublished(t *testing.T, topic, payload string) {
+	t.Helper()
+	eventually(t, fmt.Sprintf("%q on %s", payload, topic), func() bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return b.retained[topic] == payload
+	})
+}
+
+func TestBridge(t *testing.T) {
+	sim := simulator.New(simulator.Config{Inputs: 4, Outputs: 4})
+	// Labels that look like input numbers must not be taken for them.
+	sim.SetInputLabel(0, "3")
+	if err := sim.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sim.Close() })
+	host, port, _ := net.SplitHostPort(sim.Addr().String())
+	p, _ := strconv.Atoi(port)
+	vh, err := videohub.NewVideohubWithOptions(host, videohub.WithPort(p), videohub.WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { vh.Close() })
+
+	broker := startBroker(t)
+	b := New(vh, Config{
+		Broker:    broker.l.Addr().String(),
+		Discovery: true,
+		Logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() { runErr <- b.Run(ctx) }()
+
+	base := "videohub/7C2E0D000000/"
+	eventually(t, "subscriptions", func() bool {
+		broker.mu.Lock()
+		defer broker.mu.Unlock()
+		return len(broker.subscriptions) == 4
+	})
+	broker.mu.Lock()
+	wantFilters := []string{base + "output/+/source/set", base + "output/+/source_label/set", base + "output/+/label/set", base + "input/+/label/set"}
+	if !reflect.DeepEqual(broker.subscriptions, wantFilters) {
+		t.Errorf("subscriptions %q, want %q", broker.subscriptions, wantFilters)
+	}
+	broker.mu.Unlock()
+	for topic, payload := range map[string]string{
+		"availability":          "online",
+		"input/0/label":         "3",
+		"output/1/label":        "Output 2",
+		"output/1/source":       "1",
+		"output/1/source_label": "Input 2",
+		"output/3/lock":         "unlocked",
+	} {
+		broker.waitPublished(t, base+topic, payload)
+	}
+
+	broker.mu.Lock()
+	var discovery discoverySelect
+	err = json.Unmarshal([]byte(broker.retained["homeassistant/select/videohub_7C2E0D000000/output_2/config"]), &discovery)
+	broker.mu.Unlock()
+	if err != nil {
+		t.Fatalf("discovery payload: %v", err)
+	}
+	if discovery.CommandTopic != base+"output/2/source_label/set" || discovery.StateTopic != base+"output/2/source_label" {
+		t.Errorf("discovery topics %q and %q", discovery.StateTopic, discovery.CommandTopic)
+	}
+	if want := []string{"3", "Input 2", "Input 3", "Input 4"}; !reflect.DeepEqual(discovery.Options, want) {
+		t.Errorf("discovery options %q, want %q", discovery.Options, want)
+	}
+
+	broker.send(t, base+"output/1/source/set", "2")
+	broker.waitPublished(t, base+"output/1/source_label", "Input 3")
+	broker.send(t, base+"output/2/source_label/set", "3")
+	broker.waitPublished(t, base+"output/2/source_label", "3")
+	broker.send(t, base+"input/3/label/set", "Camera")
+	broker.waitPublished(t, base+"input/3/label", "Camera")
+	if got, want := sim.Routing(), []int{0, 2, 0, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("routing %v, want %v", got, want)
+	}
+
+	// Changes from elsewhere are published too.
+	sim.Route(3, 1)
+	broker.waitPublished(t, base+"output/3/source", "1")
+
+	cancel()
+	select {
+	case err := <-runErr:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Run after cancel = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
@@ -0,0 +1,305 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types (section 2.2.1), shifted into the high
+// nibble of the first header byte.
+const (
+	packetConnect    = 1 << 4
+	packetConnack    = 2 << 4
+	packetPublish    = 3 << 4
+	packetPuback     = 4 << 4
+	packetSubscribe  = 8 << 4
+	packetSuback     = 9 << 4
+	packetPingreq    = 12 << 4
+	packetPingresp   = 13 << 4
+	packetDisconnect = 14 << 4
+)
+
+// connackErrors are the CONNACK return codes (section 3.2.2.3).
+var connackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// will is the message the broker publishes if the client goes away without
+// disconnecting.
+type will struct {
+	topic   string
+	payload []byte
+	retain  bool
+}
+
+// client is a minimal MQTT 3.1.1 client: it publishes and subscribes at QoS 0
+// only, which is all the bridge needs, and keeps the connection alive with
+// PINGREQ.
+type client struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	keepAlive time.Duration
+	writeMu   sync.Mutex
+	packetID  uint16
+}
+
+// dialMQTT connects to the broker at addr and completes the CONNECT
+// handshake.
+func dialMQTT(ctx context.Context, addr, clientID, username, password string, keepAlive time.Duration, w *will) (*client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("mqtt: failed to connect to %s: %w", addr, err)
+	}
+	c := &client{conn: conn, reader: bufio.NewReader(conn), keepAlive: keepAlive}
+
+	flags := byte(0x02) // Clean session
+	var payload []byte
+	payload = appendString(payload, clientID)
+	if w != nil {
+		flags |= 0x04
+		if w.retain {
+			flags |= 0x20
+		}
+		payload = appendString(payload, w.topic)
+		payload = appendBytes(payload, w.payload)
+	}
+	if username != "" {
+		flags |= 0x80
+		payload = appendString(payload, username)
+		if password != "" {
+			flags |= 0x40
+			payload = appendString(payload, password)
+		}
+	}
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(keepAlive/time.Second))
+	body = append(body, payload...)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if err := c.write(packetConnect, body); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	header, ack, err := c.readPacket()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("mqtt: no CONNACK from broker: %w", err)
+	}
+	if header&0xF0 != packetConnack || len(ack) != 2 {
+		conn.Close()
+		return nil, errors.New("mqtt: broker did not answer CONNECT with CONNACK")
+	}
+	if ack[1] != 0 {
+		conn.Close()
+		reason, ok := connackErrors[ack[1]]
+		if !ok {
+			reason = fmt.Sprintf("return code %d", ack[1])
+		}
+		return nil, fmt.Errorf("mqtt: connection refused: %s", reason)
+	}
+	conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// publish sends payload to topic at QoS 0.
+func (c *client) publish(topic string, payload []byte, retain bool) error {
+	header := byte(packetPublish)
+	if retain {
+		header |= 0x01
+	}
+	body := appendString(nil, topic)
+	return c.write(header, append(body, payload...))
+}
+
+// subscribe asks for messages matching filter at QoS 0. The SUBACK is
+// checked by run.
+func (c *client) subscribe(filter string) error {
+	c.writeMu.Lock()
+	c.packetID++
+	if c.packetID == 0 {
+		c.packetID = 1
+	}
+	id := c.packetID
+	c.writeMu.Unlock()
+	body := binary.BigEndian.AppendUint16(nil, id)
+	body = appendString(body, filter)
+	body = append(body, 0)
+	return c.write(packetSubscribe|0x02, body)
+}
+
+// run reads packets until the connection fails or ctx is done, passing
+// every PUBLISH to handle and pinging the broker when idle. It always returns
+// a non-nil error.
+func (c *client) run(ctx context.Context, handle func(topic string, payload []byte)) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.conn.Close()
+		case <-done:
+		}
+	}()
+	if c.keepAlive > 0 {
+		go c.ping(done)
+	}
+	for {
+		if c.keepAlive > 0 {
+			// The broker answers our pings, so silence for longer than
+			// the keepalive means the connection is dead.
+			c.conn.SetReadDeadline(time.Now().Add(c.keepAlive * 3 / 2))
+		}
+		header, body, err := c.readPacket()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("mqtt: connection lost: %w", err)
+		}
+		switch header & 0xF0 {
+		case packetPublish:
+			topic, payload, err := c.parsePublish(header, body)
+			if err != nil {
+				return err
+			}
+			handle(topic, payload)
+		case packetSuback:
+			if len(body) > 2 && body[2] == 0x80 {
+				return errors.New("mqtt: broker refused subscription")
+			}
+		}
+	}
+}
+
+// parsePublish splits a PUBLISH packet and acknowledges it if the broker sent
+// it at QoS 1.
+func (c *client) parsePublish(header byte, body []byte) (string, []byte, error) {
+	topic, rest, ok := readString(body)
+	if !ok {
+		return "", nil, errors.New("mqtt: malformed PUBLISH")
+	}
+	switch qos := header >> 1 & 0x03; qos {
+	case 0:
+	case 1:
+		if len(rest) < 2 {
+			return "", nil, errors.New("mqtt: malformed PUBLISH")
+		}
+		if err := c.write(packetPuback, rest[:2]); err != nil {
+			return "", nil, err
+		}
+		rest = rest[2:]
+	default:
+		return "", nil, fmt.Errorf("mqtt: unsupported PUBLISH QoS %d", qos)
+	}
+	return topic, rest, nil
+}
+
+// ping sends PINGREQ every half keepalive until done is closed.
+func (c *client) ping(done <-chan struct{}) {
+	ticker := time.NewTicker(c.keepAlive / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.write(packetPingreq, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// close disconnects cleanly, so that the broker does not publish the will.
+func (c *client) close() error {
+	c.write(packetDisconnect, nil)
+	return c.conn.Close()
+}
+
+func (c *client) write(header byte, body []byte) error {
+	packet := append([]byte{header}, appendLength(nil, len(body))...)
+	packet = append(packet, body...)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := c.conn.Write(packet); err != nil {
+		return fmt.Errorf("mqtt: failed to send packet: %w", err)
+	}
+	return nil
+}
+
+func (c *client) readPacket() (byte, []byte, error) {
+	header, err := c.reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var length int
+	for shift := 0; ; shift += 7 {
+		if shift > 21 {
+			return 0, nil, errors.New("mqtt: malformed remaining length")
+		}
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length |= int(b&0x7F) << shift
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+// appendLength appends n in the variable length encoding of section 2.2.3.
+func appendLength(b []byte, n int) []byte {
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			return b
+		}
+	}
+}
+
+func appendString(b []byte, s string) []byte {
+	return appendBytes(b, []byte(s))
+}
+
+func appendBytes(b, data []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+	return append(b, data...)
+}
+
+func readString(b []byte) (string, []byte, bool) {
+	if len(b) < 2 {
+		return "", nil, false
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, false
+	}
+	return string(b[2 : 2+n]), b[2+n:], true
+}
@@ -0,0 +1,286 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pipeClient returns a client over one end of a pipe and a client framing
+// packets on the other end, playing the broker.
+func pipeClient(t *testing.T, keepAlive time.Duration) (*client, *client) {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+	return &client{conn: a, reader: bufio.NewReader(a), keepAlive: keepAlive},
+		&client{conn: b, reader: bufio.NewReader(b)}
+}
+
+// expectPacket reads the next packet from c and checks it.
+func expectPacket(t *testing.T, c *client, header byte, body []byte) {
+	t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	gotHeader, gotBody, err := c.readPacket()
+	if err != nil {
+		t.Fatalf("reading packet: %v", err)
+	}
+	if gotHeader != header || !bytes.Equal(gotBody, body) {
+		t.Errorf("packet %#x % x, want %#x % x", gotHeader, gotBody, header, body)
+	}
+}
+
+func TestRemainingLength(t *testing.T) {
+	tests := []struct {
+		n    int
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7F}},
+		{128, []byte{0x80, 0x01}},
+		{16383, []byte{0xFF, 0x7F}},
+		{16384, []byte{0x80, 0x80, 0x01}},
+		{2097151, []byte{0xFF, 0xFF, 0x7F}},
+		{2097152, []byte{0x80, 0x80, 0x80, 0x01}},
+		{268435455, []byte{0xFF, 0xFF, 0xFF, 0x7F}},
+	}
+	for _, tt := range tests {
+		if got := appendLength(nil, tt.n); !bytes.Equal(got, tt.want) {
+			t.Errorf("appendLength(%d) = % x, want % x", tt.n, got, tt.want)
+		}
+		if tt.n > 1<<16 {
+			continue
+		}
+		body := bytes.Repeat([]byte{'x'}, tt.n)
+		packet := append(append([]byte{packetPublish}, tt.want...), body...)
+		c := &client{reader: bufio.NewReader(bytes.NewReader(packet))}
+		header, got, err := c.readPacket()
+		if err != nil || header != packetPublish || !bytes.Equal(got, body) {
+			t.Errorf("readPacket of a %d byte body = %#x, %d bytes, %v", tt.n, header, len(got), err)
+		}
+	}
+}
+
+func TestReadPacketMalformed(t *testing.T) {
+	for name, packet := range map[string][]byte{
+		"length too long":  {packetPublish, 0xFF, 0xFF, 0xFF, 0xFF, 0x01},
+		"truncated length": {packetPublish, 0x80},
+		"truncated body":   {packetPublish, 0x05, 'a', 'b'},
+		"empty":            {},
+	} {
+		c := &client{reader: bufio.NewReader(bytes.NewReader(packet))}
+		if _, _, err := c.readPacket(); err == nil {
+			t.Errorf("%s: readPacket succeeded", name)
+		}
+	}
+}
+
+func TestReadString(t *testing.T) {
+	s, rest, ok := readString([]byte{0x00, 0x03, 'a', '/', 'b', 'x'})
+	if !ok || s != "a/b" || !bytes.Equal(rest, []byte("x")) {
+		t.Errorf("readString = %q, %q, %v", s, rest, ok)
+	}
+	for _, b := range [][]byte{nil, {0x00}, {0x00, 0x03, 'a', 'b'}} {
+		if _, _, ok := readString(b); ok {
+			t.Errorf("readString(% x) succeeded", b)
+		}
+	}
+}
+
+// dialStub starts a listener that answers the first CONNECT it reads with
+// the CONNACK return code, and returns its address and a channel receiving
+// the CONNECT body.
+func dialStub(t *testing.T, code byte) (string, <-chan []byte) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	connects := make(chan []byte, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		broker := &client{conn: conn, reader: bufio.NewReader(conn)}
+		header, body, err := broker.readPacket()
+		if err != nil || header != packetConnect {
+			return
+		}
+		connects <- body
+		broker.write(packetConnack, []byte{0, code})
+		// Hold the connection until the client closes it.
+		broker.readPacket()
+	}()
+	return l.Addr().String(), connects
+}
+
+func TestDialConnect(t *testing.T) {
+	addr, connects := dialStub(t, 0)
+	c, err := dialMQTT(context.Background(), addr, "id", "user", "pass", 30*time.Second,
+		&will{topic: "t/availability", payload: []byte("offline"), retain: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.close()
+
+	var want []byte
+	want = append(want, 0x00, 0x04, 'M', 'Q', 'T', 'T', 4)
+	want = append(want, 0xE6)       // User name, password, will retain, will, clean session
+	want = append(want, 0x00, 0x1E) // Keepalive
+	want = append(want, 0x00, 0x02, 'i', 'd')
+	want = append(want, 0x00, 0x0E)
+	want = append(want, "t/availability"...)
+	want = append(want, 0x00, 0x07)
+	want = append(want, "offline"...)
+	want = append(want, 0x00, 0x04, 'u', 's', 'e', 'r')
+	want = append(want, 0x00, 0x04, 'p', 'a', 's', 's')
+	if got := <-connects; !bytes.Equal(got, want) {
+		t.Errorf("CONNECT body\n% x\nwant\n% x", got, want)
+	}
+}
+
+func TestDialConnectMinimal(t *testing.T) {
+	addr, connects := dialStub(t, 0)
+	c, err := dialMQTT(context.Background(), addr, "id", "", "", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.close()
+	want := []byte{0x00, 0x04, 'M', 'Q', 'T', 'T', 4, 0x02, 0x00, 0x00, 0x00, 0x02, 'i', 'd'}
+	if got := <-connects; !bytes.Equal(got, want) {
+		t.Errorf("CONNECT body % x, want % x", got, want)
+	}
+}
+
+func TestDialRefused(t *testing.T) {
+	for code, reason := range map[byte]string{4: "bad user name or password", 5: "not authorized", 9: "return code 9"} {
+		addr, _ := dialStub(t, code)
+		_, err := dialMQTT(context.Background(), addr, "id", "user", "pass", time.Minute, nil)
+		if err == nil || !strings.Contains(err.Error(), reason) {
+			t.Errorf("CONNACK code %d: dialMQTT = %v, want %q", code, err, reason)
+		}
+	}
+}
+
+func TestPublishAndSubscribePackets(t *testing.T) {
+	c, broker := pipeClient(t, 0)
+
+	go c.publish("a/b", []byte("on"), true)
+	expectPacket(t, broker, packetPublish|0x01, []byte{0x00, 0x03, 'a', '/', 'b', 'o', 'n'})
+	go c.publish("a/b", nil, false)
+	expectPacket(t, broker, packetPublish, []byte{0x00, 0x03, 'a', '/', 'b'})
+
+	go c.subscribe("x/+")
+	expectPacket(t, broker, packetSubscribe|0x02, []byte{0x00, 0x01, 0x00, 0x03, 'x', '/', '+', 0x00})
+	go c.subscribe("y/#")
+	expectPacket(t, broker, packetSubscribe|0x02, []byte{0x00, 0x02, 0x00, 0x03, 'y', '/', '#', 0x00})
+
+	go c.close()
+	expectPacket(t, broker, packetDisconnect, []byte{})
+}
+
+func TestRunDeliversPublish(t *testing.T) {
+	c, broker := pipeClient(t, 0)
+	type message struct {
+		topic   string
+		payload string
+	}
+	messages := make(chan message, 2)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- c.run(context.Background(), func(topic string, payload []byte) {
+			messages <- message{topic, string(payload)}
+		})
+	}()
+
+	broker.write(packetPublish, []byte{0x00, 0x01, 'a', '1'})
+	// At QoS 1 the client must answer with the packet identifier.
+	go broker.write(packetPublish|0x02, []byte{0x00, 0x01, 'b', 0x12, 0x34, '2'})
+	expectPacket(t, broker, packetPuback, []byte{0x12, 0x34})
+	for _, want := range []message{{"a", "1"}, {"b", "2"}} {
+		select {
+		case got := <-messages:
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("message %+v, want %+v", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("message %+v not delivered", want)
+		}
+	}
+
+	broker.write(packetPublish|0x04, []byte{0x00, 0x01, 'c', 0x00, 0x01, '3'})
+	select {
+	case err := <-runErr:
+		if err == nil || !strings.Contains(err.Error(), "QoS 2") {
+			t.Errorf("run after a QoS 2 PUBLISH = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("run accepted a QoS 2 PUBLISH")
+	}
+}
+
+func TestRunRefusedSubscription(t *testing.T) {
+	c, broker := pipeClient(t, 0)
+	runErr := make(chan error, 1)
+	go func() { runErr <- c.run(context.Background(), func(string, []byte) {}) }()
+	broker.write(packetSuback, []byte{0x00, 0x01, 0x00})
+	broker.write(packetSuback, []byte{0x00, 0x02, 0x80})
+	select {
+	case err := <-runErr:
+		if err == nil || !strings.Contains(err.Error(), "refused subscription") {
+			t.Errorf("run after a refused SUBACK = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("run ignored a refused subscription")
+	}
+}
+
+func TestRunKeepalive(t *testing.T) {
+	c, broker := pipeClient(t, 40*time.Millisecond)
+	runErr := make(chan error, 1)
+	go func() { runErr <- c.run(context.Background(), func(string, []byte) {}) }()
+
+	expectPacket(t, broker, packetPingreq, []byte{})
+	broker.write(packetPingresp, nil)
+	expectPacket(t, broker, packetPingreq, []byte{})
+	// A broker that stops answering is detected after one and a half
+	// keepalives; keep draining pings so that only the read times out.
+	go func() {
+		for {
+			if _, _, err := broker.readPacket(); err != nil {
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-runErr:
+		if err == nil || !strings.Contains(err.Error(), "connection lost") {
+			t.Errorf("run with a silent broker = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("silent broker not detected")
+	}
+}
+
+func TestRunContextDone(t *testing.T) {
+	c, _ := pipeClient(t, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() { runErr <- c.run(ctx, func(string, []byte) {}) }()
+	cancel()
+	select {
+	case err := <-runErr:
+		if err != context.Canceled {
+			t.Errorf("run after cancel = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("run did not return after cancel")
+	}
+}
@@ -0,0 +1,153 @@
+package videohub
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// DefaultPort is the TCP port of the Videohub Ethernet Protocol.
+const DefaultPort = 9990
+
+// DefaultCommandTimeout is how long command methods wait for ACK/NAK.
+const DefaultCommandTimeout = 5 * time.Second
+
+// Option configures a Videohub created with NewVideohubWithOptions.
+type Option func(*Videohub)
+
+// WithPort connects to port instead of DefaultPort.
+func WithPort(port int) Option {
+	return func(vh *Videohub) {
+		vh.port = port
+	}
+}
+
+// WithDialTimeout bounds how long establishing the TCP connection may take.
+// Zero, the default, leaves it to the operating system.
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(vh *Videohub) {
+		vh.dialTimeout = timeout
+	}
+}
+
+// WithCommandTimeout sets how long command methods such as Route wait for the
+// device to answer.
+func WithCommandTimeout(timeout time.Duration) Option {
+	return func(vh *Videohub) {
+		vh.commandTimeout = timeout
+	}
+}
+
+// WithWriteTimeout bounds how long writing a command to the socket may take.
+// A write that times out drops the connection, since the device may have
+// received part of the command. Zero, the default, means no limit.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(vh *Videohub) {
+		vh.writeTimeout = timeout
+	}
+}
+
+// WithMaxRetries makes the Videohub give up after n failed reconnect attempts
+// in a row and enter StateFailed; commands then fail with ErrReconnectFailed.
+// Zero, the default, retries until Close is called.
+func WithMaxRetries(n int) Option {
+	return func(vh *Videohub) {
+		vh.maxRetries = n
+	}
+}
+
+// WithKeepalive makes the Videohub send a PING whenever nothing has been
+// received from the device for interval, and drop and re-establish the
+// connection if the ping is not answered within another interval. Zero, the
+// default, disables keepalives.
+func WithKeepalive(interval time.Duration) Option {
+	return func(vh *Videohub) {
+		vh.keepaliveInterval = interval
+	}
+}
+
+// DialFunc opens the connection to the Videohub at addr ("host:port").
+type DialFunc func(ctx context.Context, addr string) (net.Conn, error)
+
+// WithDialer makes the Videohub open connections, including reconnects, with
+// dial instead of a plain TCP dial. The dial timeout still applies through ctx.
+func WithDialer(dial DialFunc) Option {
+	return func(vh *Videohub) {
+		vh.dialer = dial
+	}
+}
+
+// WithLogger sends diagnostic output to logger instead of standard error. A
+// nil logger discards it. Records below the level set with WithLogLevel are
+// dropped before they reach logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(vh *Videohub) {
+		if logger == nil {
+			logger = slog.New(discardHandler{})
+		}
+		vh.logger = logger
+	}
+}
+
+// WithPrintfLogger sends diagnostic output, formatted as text lines, to a
+// Printf-style logger such as *log.Logger. A nil logger discards it.
+func WithPrintfLogger(logger Logger) Option {
+	return func(vh *Videohub) {
+		if logger == nil {
+			logger = NopLogger
+		}
+		vh.logger = slog.New(&printfHandler{logger: logger})
+	}
+}
+
+// WithLogLevel sets the minimum level of diagnostic output, slog.LevelInfo by
+// default. Protocol traffic is logged at slog.LevelDebug.
+func WithLogLevel(level slog.Level) Option {
+	return func(vh *Videohub) {
+		vh.logLevel = level
+	}
+}
+
+// WithRelockOnReconnect makes the Videohub lock again, after a reconnect, the
+// outputs this client held locked when the connection dropped. Devices
+// release the locks of a client when its connection drops, so without it they
+// are lost. Outputs another client locked in the meantime, or any locks after
+// a different device answers, are left alone.
+func WithRelockOnReconnect(relock bool) Option {
+	return func(vh *Videohub) {
+		vh.relock = relock
+	}
+}
+
+// Settings made by WithResilientMode.
+const (
+	ResilientDialTimeout  = 5 * time.Second
+	ResilientWriteTimeout = 5 * time.Second
+	ResilientKeepalive    = 10 * time.Second
+)
+
+// WithResilientMode configures the Videohub for unattended production use in
+// one option. It turns on:
+//
+//   - reconnecting with exponential backoff until Close, with no retry limit
+//     (WithMaxRetries(0));
+//   - a dial timeout of ResilientDialTimeout (WithDialTimeout);
+//   - a write timeout of ResilientWriteTimeout (WithWriteTimeout);
+//   - keepalive pings every ResilientKeepalive (WithKeepalive);
+//   - locking owned outputs again after a reconnect (WithRelockOnReconnect).
+//
+// Independently of any option, the cached state is marked stale when the
+// connection drops and refreshed from the dump the device sends on
+// reconnect, handlers and subscriptions carry over to the new connection,
+// and all state is discarded if a different device answers. Options given
+// after WithResilientMode override the parts of it they set.
+func WithResilientMode() Option {
+	return func(vh *Videohub) {
+		vh.maxRetries = 0
+		vh.dialTimeout = ResilientDialTimeout
+		vh.writeTimeout = ResilientWriteTimeout
+		vh.keepaliveInterval = ResilientKeepalive
+		vh.relock = true
+	}
+}
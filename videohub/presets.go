@@ -0,0 +1,134 @@
+package videohub
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Preset is a named routing state (a "salvo") that can be recalled later.
+type Preset struct {
+	Name     string    `json:"name"`
+	UniqueID string    `json:"uniqueId,omitempty"` // Device the preset was captured from
+	Created  time.Time `json:"created"`
+	Routing  []int     `json:"routing"` // Input for each output, -1 to leave the output alone
+}
+
+// Presets is a collection of presets keyed by name, as stored on disk.
+type Presets map[string]Preset
+
+// CapturePreset records the current routing as a preset called name.
+func (vh *Videohub) CapturePreset(name string) Preset {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return Preset{
+		Name:     name,
+		UniqueID: vh.uniqueID,
+		Created:  time.Now(),
+		Routing:  append([]int{}, vh.routing...),
+	}
+}
+
+// RouteDiff is one crosspoint changed by ApplyPreset.
+type RouteDiff struct {
+	Destination int `json:"destination"`
+	From        int `json:"from"` // -1 if previously unknown
+	To          int `json:"to"`
+}
+
+// ApplyReport describes what ApplyPreset did.
+type ApplyReport struct {
+	Changed   []RouteDiff `json:"changed"`
+	Unchanged int         `json:"unchanged"` // Outputs already routed as in the preset
+	Locked    []int       `json:"locked"`    // Outputs skipped because another client holds the lock
+	Invalid   []int       `json:"invalid"`   // Outputs skipped because they or their input do not exist
+}
+
+// ApplyPreset routes the device as recorded in p. Only crosspoints that differ
+// from the current routing are sent, all in a single VIDEO OUTPUT ROUTING
+// block so they take effect together. Outputs locked by another client or out
+// of range are skipped and listed in the report.
+func (vh *Videohub) ApplyPreset(p Preset) (ApplyReport, error) {
+	var report ApplyReport
+	inputs, outputs, ready := vh.Dimensions()
+	if !ready {
+		return report, ErrNotReady
+	}
+	current := vh.Routing()
+	locks := vh.Locks()
+
+	var routes [][2]int
+	for destination, source := range p.Routing {
+		switch {
+		case source < 0:
+			continue
+		case destination >= outputs || source >= inputs:
+			report.Invalid = append(report.Invalid, destination)
+		case destination < len(current) && current[destination] == source:
+			report.Unchanged++
+		case destination < len(locks) && locks[destination] == LockLocked:
+			report.Locked = append(report.Locked, destination)
+		default:
+			from := -1
+			if destination < len(current) {
+				from = current[destination]
+			}
+			report.Changed = append(report.Changed, RouteDiff{Destination: destination, From: from, To: source})
+			routes = append(routes, [2]int{destination, source})
+		}
+	}
+	if len(routes) == 0 {
+		return report, nil
+	}
+	if err := vh.BulkRoute(routes); err != nil {
+		report.Changed = nil
+		return report, err
+	}
+	return report, nil
+}
+
+// LoadPresets reads presets saved with Presets.Save.
+func LoadPresets(path string) (Presets, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	presets := Presets{}
+	if err := json.Unmarshal(data, &presets); err != nil {
+		return nil, fmt.Errorf("videohub: failed to parse presets %s: %w", path, err)
+	}
+	return presets, nil
+}
+
+// Save writes the presets to path as JSON, replacing the file atomically. A
+// file that already exists keeps its permissions; a new one is created
+// readable by everyone (0644), so that other processes can load it.
+func (p Presets) Save(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,67 @@
+package videohub
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPresetsSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "presets.json")
+	presets := Presets{
+		"news": {Name: "news", UniqueID: "7C2E0DA4BFC0", Created: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), Routing: []int{3, -1, 0}},
+		"idle": {Name: "idle", Created: time.Date(2024, 5, 2, 8, 30, 0, 0, time.UTC), Routing: []int{0, 0, 0}},
+	}
+	if err := presets.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o644 {
+		t.Errorf("new presets file mode %v, want 0644", mode)
+	}
+	loaded, err := LoadPresets(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, presets) {
+		t.Errorf("LoadPresets = %+v, want %+v", loaded, presets)
+	}
+
+	// Replacing the file keeps permissions set by the user.
+	if err := os.Chmod(path, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	delete(presets, "idle")
+	if err := presets.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o640 {
+		t.Errorf("replaced presets file mode %v, want 0640", mode)
+	}
+	if loaded, err = LoadPresets(path); err != nil || !reflect.DeepEqual(loaded, presets) {
+		t.Errorf("LoadPresets after replacing = %+v, %v; want %+v", loaded, err, presets)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("%d files left in the directory, want only the presets", len(entries))
+	}
+}
+
+func TestLoadPresetsErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := LoadPresets(filepath.Join(dir, "missing.json")); !os.IsNotExist(err) {
+		t.Errorf("LoadPresets of a missing file = %v, want a not-exist error", err)
+	}
+	path := filepath.Join(dir, "broken.json")
+	os.WriteFile(path, []byte("{"), 0o644)
+	if _, err := LoadPresets(path); err == nil {
+		t.Error("LoadPresets of malformed JSON succeeded")
+	}
+}
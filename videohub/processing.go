@@ -0,0 +1,44 @@
+package videohub
+
+// ProcessingUnits returns the number of video processing units, or 0 on
+// devices without them.
+func (vh *Videohub) ProcessingUnits() int {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return vh.processingUnits
+}
+
+// ProcessingUnitRouting returns a copy of the input assigned to each
+// processing unit, or -1 if not yet known.
+func (vh *Videohub) ProcessingUnitRouting() []int {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return append([]int(nil), vh.processingRouting...)
+}
+
+// FrameLabels returns a copy of the frame labels reported in FRAME LABELS.
+func (vh *Videohub) FrameLabels() []string {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return append([]string(nil), vh.frameLabels...)
+}
+
+// FrameBufferRouting returns a copy of the source assigned to each frame
+// buffer, as reported in FRAME BUFFER ROUTING, or -1 if not yet known.
+func (vh *Videohub) FrameBufferRouting() []int {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return append([]int(nil), vh.frameRouting...)
+}
+
+func (vh *Videohub) processProcessingUnitRouting(contents []string) {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	updateRouting(&vh.processingRouting, vh.portLimit(vh.processingUnits), contents)
+}
+
+func (vh *Videohub) processFrameBufferRouting(contents []string) {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	updateRouting(&vh.frameRouting, maxPorts, contents)
+}
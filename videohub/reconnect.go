@@ -0,0 +1,156 @@
+package videohub
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// ErrReconnectFailed is returned by commands once the Videohub has given up
+// reconnecting after the number of attempts set with WithMaxRetries.
+var ErrReconnectFailed = errors.New("videohub: reconnect attempts exhausted")
+
+const (
+	reconnectMinDelay = 250 * time.Millisecond
+	reconnectMaxDelay = 30 * time.Second
+)
+
+// reconnect replaces a dropped connection, retrying with exponential backoff
+// until it succeeds, the Videohub is closed or the retry limit is reached.
+// Close interrupts both the backoff wait and a dial in progress. The device
+// re-sends its full state on the new connection, which refreshes the cache.
+func (vh *Videohub) reconnect() error {
+	if vh.closed() {
+		return ErrClosed
+	}
+	vh.logger.Info("Reconnecting to Videohub")
+	vh.setState(StateReconnecting)
+	vh.currentConn().Close()
+	vh.markStale()
+
+	delay := reconnectMinDelay
+	for attempt := 1; ; attempt++ {
+		err := vh.connect(vh.ctx)
+		if err == nil {
+			vh.metrics.reconnects.Add(1)
+			return nil
+		}
+		if errors.Is(err, ErrClosed) {
+			return err
+		}
+		if vh.maxRetries > 0 && attempt >= vh.maxRetries {
+			vh.setState(StateFailed)
+			return fmt.Errorf("%w after %d attempts: %v", ErrReconnectFailed, attempt, err)
+		}
+		wait := jitter(delay)
+		vh.logger.Warn("Reconnect failed", "error", err, "retry_in", wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-vh.ctx.Done():
+			timer.Stop()
+			return ErrClosed
+		case <-timer.C:
+		}
+		delay = min(delay*2, reconnectMaxDelay)
+	}
+}
+
+// jitter spreads d randomly over [d/2, d) so that several clients do not
+// retry in lockstep.
+func jitter(d time.Duration) time.Duration {
+	return d/2 + rand.N(d/2)
+}
+
+// markStale flags the cached state as possibly out of date until the device
+// has sent a complete dump on the new connection.
+func (vh *Videohub) markStale() {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	vh.stale = true
+	vh.dumpSeen = nil
+	if vh.relock && vh.relockOutputs == nil {
+		for o, state := range vh.locks {
+			if state == LockOwned {
+				vh.relockOutputs = append(vh.relockOutputs, o)
+			}
+		}
+		vh.relockID = vh.uniqueID
+	}
+}
+
+// takeRelock returns the outputs to lock again now that the dump after a
+// reconnect is complete, if they are still on the same device. The caller
+// must hold vh.mu.
+func (vh *Videohub) takeRelock() []int {
+	outputs := vh.relockOutputs
+	vh.relockOutputs = nil
+	if vh.uniqueID != vh.relockID {
+		return nil
+	}
+	return outputs
+}
+
+// relockAfterReconnect locks outputs again, skipping those another client
+// has locked since. It runs on its own goroutine since the reader must be
+// free to receive the answer.
+func (vh *Videohub) relockAfterReconnect(outputs []int) {
+	var lines []string
+	for _, o := range outputs {
+		switch vh.LockState(o) {
+		case LockUnlocked:
+			lines = append(lines, fmt.Sprintf("%d %s", o, LockOwned))
+		case LockLocked:
+			vh.logger.Warn("Output locked by another client while disconnected", "output", o)
+		}
+	}
+	if len(lines) == 0 {
+		return
+	}
+	vh.logger.Info("Locking outputs again after reconnect", "outputs", len(lines))
+	if err := vh.sendSync(buildBlock(string(BlockVideoOutputLocks), lines)); err != nil {
+		vh.logger.Warn("Failed to lock outputs again after reconnect", "error", err)
+	}
+}
+
+// Stale reports whether the connection has dropped since the cached state was
+// last refreshed by a complete dump from the device.
+func (vh *Videohub) Stale() bool {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return vh.stale
+}
+
+// OnConnect registers fn to be called each time a connection to the device is
+// re-established. The device re-sends its full state right after.
+func (vh *Videohub) OnConnect(fn func()) {
+	vh.handlersMu.Lock()
+	defer vh.handlersMu.Unlock()
+	vh.connectHandlers = append(vh.connectHandlers, fn)
+}
+
+// OnDisconnect registers fn to be called when the connection is lost, with the
+// error that ended it, or with nil when it is shut down by Close.
+func (vh *Videohub) OnDisconnect(fn func(err error)) {
+	vh.handlersMu.Lock()
+	defer vh.handlersMu.Unlock()
+	vh.disconnectHandlers = append(vh.disconnectHandlers, fn)
+}
+
+func (vh *Videohub) emitConnect() {
+	vh.handlersMu.Lock()
+	handlers := append([]func(){}, vh.connectHandlers...)
+	vh.handlersMu.Unlock()
+	for _, fn := range handlers {
+		fn()
+	}
+}
+
+func (vh *Videohub) emitDisconnect(err error) {
+	vh.handlersMu.Lock()
+	handlers := append([]func(err error){}, vh.disconnectHandlers...)
+	vh.handlersMu.Unlock()
+	for _, fn := range handlers {
+		fn(err)
+	}
+}
@@ -0,0 +1,162 @@
+package videohub
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recordings are text files with one protocol line per line, prefixed by the
+// time it was sent or received and its direction ('<' from the device, '>'
+// to it):
+//
+//	2026-10-14T02:47:10.684811014Z < VIDEO OUTPUT ROUTING:
+//	2026-10-14T02:47:10.684811014Z < 0 5
+//	2026-10-14T02:47:10.684811014Z <
+//
+// Lines starting with '#' are comments.
+
+// recorder writes protocol traffic in the recording format.
+type recorder struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (r *recorder) record(direction byte, lines ...string) {
+	if r == nil {
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	var b strings.Builder
+	for _, line := range lines {
+		fmt.Fprintf(&b, "%s %c %s\n", now, direction, line)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	io.WriteString(r.w, b.String())
+}
+
+// WithRecorder writes every line sent to and received from the device to w,
+// timestamped, in a format Replay can read back. Writes to w happen on the
+// reader and sender paths, so w should not block.
+func WithRecorder(w io.Writer) Option {
+	return func(vh *Videohub) {
+		io.WriteString(w, "# videohub recording\n")
+		vh.recorder = &recorder{w: w}
+	}
+}
+
+// Replay feeds the lines a device sent in a recording made with WithRecorder
+// through the parser and returns a Videohub holding the resulting state.
+// Timestamps are ignored, and lines sent to the device and the ACK or NAK
+// answering them are skipped. The Videohub stays attached to the finished
+// recording until it is closed; commands sent to it are discarded and time
+// out. Replay returns once the whole recording has been parsed or ctx is done.
+func Replay(ctx context.Context, r io.Reader, opts ...Option) (*Videohub, error) {
+	conn := &replayConn{lines: make(chan string), closed: make(chan struct{}), done: make(chan struct{})}
+	go conn.scan(bufio.NewScanner(r))
+	vh, err := NewVideohubWithConn(conn, opts...)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	select {
+	case <-conn.done:
+	case <-ctx.Done():
+		vh.Close()
+		return nil, ctx.Err()
+	}
+	if err := conn.err; err != nil {
+		vh.Close()
+		return nil, fmt.Errorf("videohub: failed to read recording: %w", err)
+	}
+	return vh, nil
+}
+
+// replayConn is a net.Conn that reads the device side of a recording and
+// then blocks until closed, so that the Videohub does not try to reconnect.
+// The recording is scanned on its own goroutine, so that closing the
+// connection also interrupts a recording that is slow to read.
+type replayConn struct {
+	lines     chan string // Device lines of the recording, closed at its end
+	pending   []byte
+	err       error // Set before lines is closed
+	doneOnce  sync.Once
+	done      chan struct{} // Closed when the recording is exhausted
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// scan sends the lines the device sent in recording to c.lines.
+func (c *replayConn) scan(recording *bufio.Scanner) {
+	defer close(c.lines)
+	for recording.Scan() {
+		// Nothing replayed is waiting for an answer, so leave responses out.
+		line, ok := parseRecordedLine(recording.Text())
+		if !ok || line == "ACK" || line == "NAK" {
+			continue
+		}
+		select {
+		case c.lines <- line:
+		case <-c.closed:
+			return
+		}
+	}
+	c.err = recording.Err()
+}
+
+// Read only reports the end of the recording once the reader asks for more,
+// by which point it has parsed everything before it.
+func (c *replayConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		select {
+		case line, ok := <-c.lines:
+			if !ok {
+				c.doneOnce.Do(func() { close(c.done) })
+				<-c.closed
+				return 0, net.ErrClosed
+			}
+			c.pending = append([]byte(line), '\n')
+		case <-c.closed:
+			return 0, net.ErrClosed
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// parseRecordedLine returns the content of a line received from the device.
+func parseRecordedLine(line string) (string, bool) {
+	if strings.HasPrefix(line, "#") {
+		return "", false
+	}
+	_, rest, ok := strings.Cut(line, " ")
+	if !ok || rest == "" || rest[0] != '<' {
+		return "", false
+	}
+	return strings.TrimPrefix(rest[1:], " "), true
+}
+
+func (c *replayConn) Write(p []byte) (int, error) { return len(p), nil }
+
+func (c *replayConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *replayConn) LocalAddr() net.Addr                { return replayAddr{} }
+func (c *replayConn) RemoteAddr() net.Addr               { return replayAddr{} }
+func (c *replayConn) SetDeadline(t time.Time) error      { return nil }
+func (c *replayConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *replayConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type replayAddr struct{}
+
+func (replayAddr) Network() string { return "replay" }
+func (replayAddr) String() string  { return "replay" }
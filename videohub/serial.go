@@ -0,0 +1,113 @@
+package videohub
+
+import (
+	"strings"
+)
+
+// SerialDirection is the role of an RS-422 serial port.
+type SerialDirection string
+
+const (
+	SerialControl SerialDirection = "control" // Port controls a deck (workstation side)
+	SerialSlave   SerialDirection = "slave"   // Port is controlled (deck side)
+	SerialAuto    SerialDirection = "auto"    // Direction is detected automatically
+)
+
+// SerialPortLabels returns a copy of the serial port labels.
+func (vh *Videohub) SerialPortLabels() []string {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return append([]string(nil), vh.serialLabels...)
+}
+
+// SerialRouting returns a copy of the serial port connected to each serial
+// port, or -1 if not yet known.
+func (vh *Videohub) SerialRouting() []int {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return append([]int(nil), vh.serialRouting...)
+}
+
+// SerialDirections returns a copy of the direction of each serial port, or ""
+// if not yet known.
+func (vh *Videohub) SerialDirections() []SerialDirection {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return append([]SerialDirection(nil), vh.serialDirections...)
+}
+
+// SerialLocks returns a copy of the lock state of each serial port, with the
+// same values as Locks.
+func (vh *Videohub) SerialLocks() []string {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return append([]string(nil), vh.serialLocks...)
+}
+
+// SerialRoute connects serial port source to serial port destination.
+func (vh *Videohub) SerialRoute(destination, source int) error {
+	if err := vh.checkSerialPort(destination); err != nil {
+		return err
+	}
+	if err := vh.checkSerialPort(source); err != nil {
+		return err
+	}
+	return vh.sendBuilt(BuildSerialRouteCommand(destination, source))
+}
+
+// SerialPortLabel sets the label of serial port port.
+func (vh *Videohub) SerialPortLabel(port int, label string) error {
+	if err := vh.checkSerialPort(port); err != nil {
+		return err
+	}
+	return vh.sendBuilt(buildLabelCommand(string(BlockSerialPortLabels), "serial port", port, label, vh.labelCharset))
+}
+
+// SetSerialDirection sets the direction of serial port port.
+func (vh *Videohub) SetSerialDirection(port int, dir SerialDirection) error {
+	if err := vh.checkSerialPort(port); err != nil {
+		return err
+	}
+	return vh.sendBuilt(BuildSerialDirectionCommand(port, dir))
+}
+
+func (vh *Videohub) processSerialRouting(contents []string) {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	updateRouting(&vh.serialRouting, vh.portLimit(vh.serialPorts), contents)
+}
+
+func (vh *Videohub) processSerialDirections(contents []string) {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	limit := vh.portLimit(vh.serialPorts)
+	for _, item := range contents {
+		parts := strings.Split(item, " ")
+		if len(parts) == 2 {
+			p, ok := parseIndex(parts[0])
+			if !ok || p >= limit {
+				continue
+			}
+			switch dir := SerialDirection(parts[1]); dir {
+			case SerialControl, SerialSlave, SerialAuto:
+				vh.serialDirections = growDirections(vh.serialDirections, p+1)
+				vh.serialDirections[p] = dir
+			}
+		}
+	}
+}
+
+func (vh *Videohub) processSerialLocks(contents []string) {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	updateLocks(&vh.serialLocks, vh.portLimit(vh.serialPorts), contents)
+}
+
+// growDirections returns directions extended to at least n entries, keeping
+// the existing ones.
+func growDirections(directions []SerialDirection, n int) []SerialDirection {
+	if len(directions) >= n {
+		return directions
+	}
+	return append(directions, make([]SerialDirection, n-len(directions))...)
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/StechLabs/pydeohub/videohub"
+)
+
+// Message is one event sent over the /events WebSocket.
+type Message struct {
+	Type  string `json:"type"` // route, label, lock, unlock, connection, device, dimensions, power or keepalive
+	Event any    `json:"event"`
+}
+
+// message wraps ev for the WebSocket, or returns false for events that are
+// not streamed.
+func message(ev videohub.Event) (Message, bool) {
+	switch ev := ev.(type) {
+	case videohub.RouteChange:
+		return Message{"route", ev}, true
+	case videohub.LabelChange:
+		return Message{"label", struct {
+			Kind string `json:"kind"`
+			videohub.LabelChange
+		}{ev.Kind.String(), ev}}, true
+	case videohub.LockChange:
+		return Message{"lock", ev}, true
+	case videohub.ForcedUnlock:
+		return Message{"unlock", ev}, true
+	case videohub.ConnectionChange:
+		return Message{"connection", map[string]string{"old": ev.Old.String(), "new": ev.New.String()}}, true
+	case videohub.DeviceChange:
+		return Message{"device", map[string]string{"oldId": ev.OldID, "newId": ev.NewID}}, true
+	case videohub.DimensionsChange:
+		return Message{"dimensions", map[string]int{"inputs": ev.Inputs, "outputs": ev.Outputs}}, true
+	case videohub.PowerSupplyFault:
+		return Message{"power", ev.PowerSupply}, true
+	case videohub.KeepaliveFailed:
+		return Message{"keepalive", map[string]any{"lastSeen": ev.LastSeen, "error": ev.Err.Error()}}, true
+	}
+	return Message{}, false
+}
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes (RFC 6455, section 5.2).
+const (
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xA
+)
+
+// handleEvents upgrades the request to a WebSocket and streams every event
+// of the Videohub as a JSON Message until either side closes it. Messages
+// from the client other than pings and close are ignored.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" || !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		writeError(w, http.StatusBadRequest, errors.New("expected a WebSocket upgrade"))
+		return
+	}
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer conn.Close()
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	events, unsubscribe := s.vh.Subscribe()
+	defer unsubscribe()
+	ws := &websocket{w: conn}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ws.readLoop(rw.Reader)
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		case ev, ok := <-events:
+			if !ok {
+				ws.writeFrame(opClose, []byte{0x03, 0xE9}) // 1001 going away
+				return
+			}
+			msg, ok := message(ev)
+			if !ok {
+				continue
+			}
+			data, err := json.Marshal(msg)
+			if err != nil {
+				continue
+			}
+			if err := ws.writeFrame(opText, data); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// websocket is the server side of a WebSocket connection, just enough of RFC
+// 6455 to push text messages.
+type websocket struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// writeFrame writes one unmasked, unfragmented frame.
+func (ws *websocket) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if _, err := ws.w.Write(header); err != nil {
+		return err
+	}
+	_, err := ws.w.Write(payload)
+	return err
+}
+
+// maxClientFrame bounds the payload of frames accepted from clients, which
+// are not expected to send anything but control frames.
+const maxClientFrame = 1 << 16
+
+// readLoop answers pings and returns when the client closes the connection
+// or sends something malformed.
+func (ws *websocket) readLoop(r io.Reader) {
+	for {
+		opcode, payload, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case opClose:
+			ws.writeFrame(opClose, payload[:min(len(payload), 2)])
+			return
+		case opPing:
+			if ws.writeFrame(opPong, payload) != nil {
+				return
+			}
+		}
+	}
+}
+
+// readFrame reads one masked client frame.
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	if head[1]&0x80 == 0 {
+		return 0, nil, errors.New("websocket: client frame not masked")
+	}
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > maxClientFrame {
+		return 0, nil, errors.New("websocket: client frame too large")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(r, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return head[0] & 0x0F, payload, nil
+}
@@ -0,0 +1,195 @@
+// Package server exposes a Videohub over HTTP, so that web interfaces and
+// automation tools can share one connection to the device.
+//
+// Endpoints:
+//
+//	GET  /routing  Input routed to each output, as a JSON array
+//	GET  /labels   Input and output labels
+//	POST /route    Route an input to an output: {"output": 3, "input": "CAM 2"}
+//	GET  /events   WebSocket stream of route, label and connection events
+//
+// Ports in POST /route are zero-based indexes or labels.
+//
+// So that a web page cannot drive the router through an operator's browser,
+// POST /route only accepts application/json bodies, and requests carrying an
+// Origin header are refused unless it matches the Host or is allowed with
+// WithAllowedOrigins.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/StechLabs/pydeohub/videohub"
+)
+
+// Server is an http.Handler serving a Videohub.
+type Server struct {
+	vh      *videohub.Videohub
+	mux     *http.ServeMux
+	origins map[string]bool // Allowed Origin values besides the request's own host
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithAllowedOrigins lets web pages served from origins (ex.
+// 'https://panel.example.com') use POST /route and /events.
+func WithAllowedOrigins(origins ...string) Option {
+	return func(s *Server) {
+		for _, origin := range origins {
+			s.origins[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+		}
+	}
+}
+
+// New returns a Server for vh, configured by opts. The caller keeps ownership
+// of vh.
+func New(vh *videohub.Videohub, opts ...Option) *Server {
+	s := &Server{vh: vh, mux: http.NewServeMux(), origins: make(map[string]bool)}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.mux.HandleFunc("/routing", method(http.MethodGet, s.handleRouting))
+	s.mux.HandleFunc("/labels", method(http.MethodGet, s.handleLabels))
+	s.mux.HandleFunc("/route", method(http.MethodPost, s.sameOrigin(s.handleRoute)))
+	s.mux.HandleFunc("/events", method(http.MethodGet, s.sameOrigin(s.handleEvents)))
+	return s
+}
+
+// sameOrigin refuses requests from browsers on pages of another origin.
+// Clients that send no Origin header, such as scripts, are not browsers
+// acting for a page and are let through.
+func (s *Server) sameOrigin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" && !s.allowedOrigin(origin, r.Host) {
+			writeError(w, http.StatusForbidden, errors.New("origin not allowed"))
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) allowedOrigin(origin, host string) bool {
+	if s.origins[strings.ToLower(origin)] {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, host)
+}
+
+// method restricts h to requests using m.
+func method(m string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != m {
+			w.Header().Set("Allow", m)
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Labels is the body of GET /labels.
+type Labels struct {
+	Inputs  []string `json:"inputs"`
+	Outputs []string `json:"outputs"`
+}
+
+// RouteRequest is the body of POST /route. Output and Input are each a
+// zero-based index or a label.
+type RouteRequest struct {
+	Output json.RawMessage `json:"output"`
+	Input  json.RawMessage `json:"input"`
+}
+
+func (s *Server) handleRouting(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.vh.Routing())
+}
+
+func (s *Server) handleLabels(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Labels{Inputs: s.vh.InputLabels(), Outputs: s.vh.OutputLabels()})
+}
+
+func (s *Server) handleRoute(w http.ResponseWriter, r *http.Request) {
+	// Requiring JSON also means browsers must ask first with a CORS
+	// preflight, which is never granted.
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, errors.New("request body must be application/json"))
+		return
+	}
+	var req RouteRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	output, err := port(req.Output, s.vh.OutputIndexByLabel)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	input, err := port(req.Input, s.vh.InputIndexByLabel)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.vh.Route(output, input); err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// port decodes an index or label from a request field.
+func port(raw json.RawMessage, byLabel func(string, ...videohub.MatchOption) (int, error)) (int, error) {
+	if len(raw) == 0 {
+		return -1, errors.New("missing output or input")
+	}
+	var index int
+	if err := json.Unmarshal(raw, &index); err == nil {
+		return index, nil
+	}
+	var label string
+	if err := json.Unmarshal(raw, &label); err != nil {
+		return -1, errors.New("output and input must be an index or a label")
+	}
+	if index, err := strconv.Atoi(label); err == nil {
+		return index, nil
+	}
+	return byLabel(label)
+}
+
+// errorStatus maps command errors to HTTP status codes.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, videohub.ErrOutputLocked):
+		return http.StatusConflict
+	case errors.Is(err, videohub.ErrNAK):
+		return http.StatusBadGateway
+	case errors.Is(err, videohub.ErrTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, videohub.ErrClosed), errors.Is(err, videohub.ErrConnectionLost), errors.Is(err, videohub.ErrReconnectFailed),
+		errors.Is(err, videohub.ErrNotReady):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StechLabs/pydeohub/videohub"
+	"github.com/StechLabs/pydeohub/videohub/simulator"
+)
+
+func newTestServer(t *testing.T, opts ...Option) *httptest.Server {
+	t.Helper()
+	sim := simulator.New(simulator.Config{Inputs: 4, Outputs: 4})
+	if err := sim.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sim.Close() })
+	host, port, _ := net.SplitHostPort(sim.Addr().String())
+	p, _ := strconv.Atoi(port)
+	vh, err := videohub.NewVideohubWithOptions(host, videohub.WithPort(p), videohub.WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { vh.Close() })
+	if err := vh.WaitForReady(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(New(vh, opts...))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestRouteRequiresJSON(t *testing.T) {
+	ts := newTestServer(t)
+	for _, tt := range []struct {
+		contentType string
+		want        int
+	}{
+		{"text/plain", http.StatusUnsupportedMediaType},
+		{"application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"", http.StatusUnsupportedMediaType},
+		{"application/json", http.StatusNoContent},
+		{"application/json; charset=utf-8", http.StatusNoContent},
+	} {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/route", strings.NewReader(`{"output": 1, "input": 2}`))
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("POST /route with Content-Type %q: status %d, want %d", tt.contentType, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestOriginChecked(t *testing.T) {
+	ts := newTestServer(t, WithAllowedOrigins("https://panel.example.com"))
+	for _, tt := range []struct {
+		origin string
+		want   int
+	}{
+		{"https://evil.example.com", http.StatusForbidden},
+		{"null", http.StatusForbidden},
+		{ts.URL, http.StatusNoContent},
+		{"https://panel.example.com", http.StatusNoContent},
+		{"", http.StatusNoContent},
+	} {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/route", strings.NewReader(`{"output": 1, "input": 2}`))
+		req.Header.Set("Content-Type", "application/json")
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("POST /route from origin %q: status %d, want %d", tt.origin, resp.StatusCode, tt.want)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/events", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Origin", "https://evil.example.com")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("GET /events from another origin: status %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
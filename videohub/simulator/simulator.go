@@ -0,0 +1,719 @@
+// Package simulator implements an in-memory Videohub that speaks the Videohub
+// Ethernet Protocol, for testing code built on the videohub package without
+// hardware.
+//
+// A Server keeps a routing matrix, labels, output locks and Take Mode
+// settings, sends the usual state dump to every client that connects,
+// answers commands with ACK or NAK and pushes every change to all connected
+// clients, like a real device.
+package simulator
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/StechLabs/pydeohub/videohub"
+)
+
+// DefaultAddr is the address ListenAndServe uses when given an empty one.
+const DefaultAddr = ":9990"
+
+// Config describes the simulated device.
+type Config struct {
+	ProtocolVersion string // Defaults to 2.3
+	Model           string // Defaults to 'Blackmagic Smart Videohub'
+	UniqueID        string // Defaults to '7C2E0D000000'
+	FriendlyName    string // Sent with protocol 2.8 and later, defaults to Model
+	Inputs          int    // Defaults to 16
+	Outputs         int    // Defaults to 16
+}
+
+// Server is a simulated Videohub. Its methods are safe for concurrent use.
+type Server struct {
+	cfg Config
+
+	mu           sync.Mutex
+	inputLabels  []string
+	outputLabels []string
+	routing      []int
+	locks        []*client // Client holding the lock on each output, nil if unlocked
+	takeMode     bool
+	takeModes    []bool // Take Mode of each output
+
+	listener net.Listener
+	clients  map[*client]struct{}
+	wg       sync.WaitGroup
+	closed   bool
+}
+
+type client struct {
+	conn    net.Conn
+	writeMu sync.Mutex // Taken after Server.mu when both are held
+}
+
+// New returns a Server for the device described by cfg. Every output starts
+// routed to the input with the same number (wrapping if there are fewer
+// inputs than outputs), with default labels and no locks.
+func New(cfg Config) *Server {
+	if cfg.ProtocolVersion == "" {
+		cfg.ProtocolVersion = "2.3"
+	}
+	if cfg.Model == "" {
+		cfg.Model = "Blackmagic Smart Videohub"
+	}
+	if cfg.UniqueID == "" {
+		cfg.UniqueID = "7C2E0D000000"
+	}
+	if cfg.Inputs <= 0 {
+		cfg.Inputs = 16
+	}
+	if cfg.Outputs <= 0 {
+		cfg.Outputs = 16
+	}
+	s := &Server{
+		cfg:          cfg,
+		inputLabels:  make([]string, cfg.Inputs),
+		outputLabels: make([]string, cfg.Outputs),
+		routing:      make([]int, cfg.Outputs),
+		locks:        make([]*client, cfg.Outputs),
+		takeModes:    make([]bool, cfg.Outputs),
+		clients:      make(map[*client]struct{}),
+	}
+	for i := range s.inputLabels {
+		s.inputLabels[i] = fmt.Sprintf("Input %d", i+1)
+	}
+	for i := range s.outputLabels {
+		s.outputLabels[i] = fmt.Sprintf("Output %d", i+1)
+		s.routing[i] = i % cfg.Inputs
+	}
+	return s
+}
+
+// ListenAndServe listens on addr (DefaultAddr if empty) and serves clients
+// until Close is called.
+func (s *Server) ListenAndServe(addr string) error {
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Start listens on addr and serves clients in the background. Use
+// "127.0.0.1:0" to pick a free port and Addr to find out which.
+func (s *Server) Start(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	// Set the listener now so that Addr works as soon as Start returns.
+	s.mu.Lock()
+	s.listener = l
+	s.mu.Unlock()
+	go s.Serve(l)
+	return nil
+}
+
+// Serve accepts clients on l until Close is called. It always returns a
+// non-nil error; after Close it is net.ErrClosed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return net.ErrClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return net.ErrClosed
+			}
+			return err
+		}
+		c := &client{conn: conn}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return net.ErrClosed
+		}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveClient(c)
+	}
+}
+
+// Addr returns the address the server is listening on, or nil before it is.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Close stops listening, disconnects every client and waits for them to be
+// cleaned up.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for c := range s.clients {
+		c.conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// DisconnectAll drops every connected client without stopping the server,
+// to exercise reconnection.
+func (s *Server) DisconnectAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		s.dropClient(c)
+	}
+}
+
+// dropClient disconnects c and releases its locks, as a real device does when
+// a connection drops; a client reconnecting right away already sees them
+// free. The caller must hold s.mu.
+func (s *Server) dropClient(c *client) {
+	delete(s.clients, c)
+	for i, owner := range s.locks {
+		if owner == c {
+			s.locks[i] = nil
+		}
+	}
+	c.conn.Close()
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Routing returns the input routed to each output.
+func (s *Server) Routing() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int(nil), s.routing...)
+}
+
+// InputLabels returns the label of each input.
+func (s *Server) InputLabels() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.inputLabels...)
+}
+
+// OutputLabels returns the label of each output.
+func (s *Server) OutputLabels() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.outputLabels...)
+}
+
+// TakeModes returns a copy of the Take Mode setting of each output.
+func (s *Server) TakeModes() []bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]bool(nil), s.takeModes...)
+}
+
+// Route routes source to destination as if it had been changed from the
+// front panel, notifying every client. Locks are ignored.
+func (s *Server) Route(destination, source int) error {
+	s.mu.Lock()
+	if destination < 0 || destination >= s.cfg.Outputs || source < 0 || source >= s.cfg.Inputs {
+		s.mu.Unlock()
+		return fmt.Errorf("simulator: route %d %d out of range", destination, source)
+	}
+	s.routing[destination] = source
+	s.mu.Unlock()
+	s.broadcast(videohub.BlockVideoOutputRouting, []string{fmt.Sprintf("%d %d", destination, source)})
+	return nil
+}
+
+// SetInputLabel changes the label of source, notifying every client.
+func (s *Server) SetInputLabel(source int, label string) error {
+	return s.setLabel(videohub.BlockInputLabels, s.inputLabels, source, label)
+}
+
+// SetOutputLabel changes the label of destination, notifying every client.
+func (s *Server) SetOutputLabel(destination int, label string) error {
+	return s.setLabel(videohub.BlockOutputLabels, s.outputLabels, destination, label)
+}
+
+func (s *Server) setLabel(header videohub.BlockType, labels []string, index int, label string) error {
+	s.mu.Lock()
+	if index < 0 || index >= len(labels) {
+		s.mu.Unlock()
+		return fmt.Errorf("simulator: %s index %d out of range", strings.ToLower(string(header)), index)
+	}
+	labels[index] = label
+	s.mu.Unlock()
+	s.broadcast(header, []string{fmt.Sprintf("%d %s", index, label)})
+	return nil
+}
+
+func (s *Server) serveClient(c *client) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		s.dropClient(c)
+		s.mu.Unlock()
+	}()
+
+	if err := s.sendDump(c); err != nil {
+		return
+	}
+	reader := bufio.NewReader(c.conn)
+	var block []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "" && block != nil:
+			s.handleBlock(c, block)
+			block = nil
+		case block != nil:
+			block = append(block, line)
+		case strings.HasSuffix(line, ":"):
+			block = []string{line}
+		}
+	}
+}
+
+// sendDump registers a newly connected client for broadcasts and writes it
+// the full state. Both happen under s.mu, and writing starts before s.mu is
+// released, so that a change made after the state was read is broadcast to c
+// after the dump, never before it or overwritten by it.
+func (s *Server) sendDump(c *client) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return net.ErrClosed
+	}
+	s.clients[c] = struct{}{}
+	var b strings.Builder
+	writeBlock(&b, videohub.BlockProtocolPreamble, []string{"Version: " + s.cfg.ProtocolVersion})
+	writeBlock(&b, videohub.BlockVideohubDevice, s.deviceLines())
+	writeBlock(&b, videohub.BlockInputLabels, labelLines(s.inputLabels))
+	writeBlock(&b, videohub.BlockOutputLabels, labelLines(s.outputLabels))
+	writeBlock(&b, videohub.BlockVideoOutputLocks, s.lockLines(c, nil))
+	writeBlock(&b, videohub.BlockVideoOutputRouting, routingLines(s.routing))
+	writeBlock(&b, videohub.BlockConfiguration, s.configurationLines())
+	writeBlock(&b, videohub.BlockTakeMode, s.takeModeLines(nil))
+	if s.endsPrelude() {
+		writeBlock(&b, videohub.BlockEndPrelude, nil)
+	}
+	c.writeMu.Lock()
+	s.mu.Unlock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write([]byte(b.String()))
+	return err
+}
+
+func (s *Server) deviceLines() []string {
+	lines := []string{
+		"Device present: true",
+		"Model name: " + s.cfg.Model,
+	}
+	if s.endsPrelude() {
+		friendly := s.cfg.FriendlyName
+		if friendly == "" {
+			friendly = s.cfg.Model
+		}
+		lines = append(lines, "Friendly name: "+friendly)
+	}
+	return append(lines,
+		"Unique ID: "+s.cfg.UniqueID,
+		fmt.Sprintf("Video inputs: %d", s.cfg.Inputs),
+		"Video processing units: 0",
+		fmt.Sprintf("Video outputs: %d", s.cfg.Outputs),
+		"Video monitoring outputs: 0",
+		"Serial ports: 0",
+	)
+}
+
+// endsPrelude reports whether the configured protocol version, like real
+// devices from 2.8 on, names the device and ends its dump with END PRELUDE.
+func (s *Server) endsPrelude() bool {
+	major, minor, _ := strings.Cut(s.cfg.ProtocolVersion, ".")
+	ma, err1 := strconv.Atoi(major)
+	mi, err2 := strconv.Atoi(minor)
+	return err1 == nil && err2 == nil && (ma > 2 || ma == 2 && mi >= 8)
+}
+
+func (s *Server) configurationLines() []string {
+	return []string{"Take Mode: " + strconv.FormatBool(s.takeMode)}
+}
+
+// takeModeLines returns the Take Mode of outputs (all of them if nil). The
+// caller must hold s.mu.
+func (s *Server) takeModeLines(outputs []int) []string {
+	if outputs == nil {
+		for i := range s.takeModes {
+			outputs = append(outputs, i)
+		}
+	}
+	lines := make([]string, 0, len(outputs))
+	for _, i := range outputs {
+		lines = append(lines, fmt.Sprintf("%d %t", i, s.takeModes[i]))
+	}
+	return lines
+}
+
+// lockLines returns the lock state of outputs (all of them if nil) as seen
+// by c. The caller must hold s.mu.
+func (s *Server) lockLines(c *client, outputs []int) []string {
+	if outputs == nil {
+		for i := range s.locks {
+			outputs = append(outputs, i)
+		}
+	}
+	lines := make([]string, 0, len(outputs))
+	for _, i := range outputs {
+		state := videohub.LockUnlocked
+		switch s.locks[i] {
+		case nil:
+		case c:
+			state = videohub.LockOwned
+		default:
+			state = videohub.LockLocked
+		}
+		lines = append(lines, fmt.Sprintf("%d %s", i, state))
+	}
+	return lines
+}
+
+// handleBlock answers one block received from c. A block without body lines
+// is a request for the current state of that block (or a keepalive for
+// PING); otherwise the changes are applied and pushed to every client. The
+// whole block is rejected with NAK if any line is malformed.
+func (s *Server) handleBlock(c *client, block []string) {
+	header := videohub.BlockType(strings.TrimSuffix(block[0], ":"))
+	lines := block[1:]
+	if header == "PING" {
+		c.write("ACK\n\n")
+		return
+	}
+	if len(lines) == 0 {
+		s.sendState(c, header)
+		return
+	}
+
+	var err error
+	switch header {
+	case videohub.BlockVideoOutputRouting:
+		err = s.applyRouting(c, lines)
+	case videohub.BlockInputLabels:
+		err = s.applyLabels(c, header, s.inputLabels, lines)
+	case videohub.BlockOutputLabels:
+		err = s.applyLabels(c, header, s.outputLabels, lines)
+	case videohub.BlockVideoOutputLocks:
+		err = s.applyLocks(c, lines)
+	case videohub.BlockConfiguration:
+		err = s.applyConfiguration(c, lines)
+	case videohub.BlockTakeMode:
+		err = s.applyTakeMode(c, lines)
+	default:
+		err = errors.New("unsupported block")
+	}
+	if err != nil {
+		c.write("NAK\n\n")
+	}
+}
+
+func (s *Server) sendState(c *client, header videohub.BlockType) {
+	s.mu.Lock()
+	var lines []string
+	switch header {
+	case videohub.BlockProtocolPreamble:
+		lines = []string{"Version: " + s.cfg.ProtocolVersion}
+	case videohub.BlockVideohubDevice:
+		lines = s.deviceLines()
+	case videohub.BlockInputLabels:
+		lines = labelLines(s.inputLabels)
+	case videohub.BlockOutputLabels:
+		lines = labelLines(s.outputLabels)
+	case videohub.BlockVideoOutputLocks:
+		lines = s.lockLines(c, nil)
+	case videohub.BlockVideoOutputRouting:
+		lines = routingLines(s.routing)
+	case videohub.BlockConfiguration:
+		lines = s.configurationLines()
+	case videohub.BlockTakeMode:
+		lines = s.takeModeLines(nil)
+	default:
+		s.mu.Unlock()
+		c.write("NAK\n\n")
+		return
+	}
+	s.mu.Unlock()
+	var b strings.Builder
+	b.WriteString("ACK\n\n")
+	writeBlock(&b, header, lines)
+	c.write(b.String())
+}
+
+// parsePair splits a body line into its index and value, checking the index
+// against n.
+func parsePair(line string, n int) (int, string, error) {
+	index, value, ok := strings.Cut(line, " ")
+	if !ok {
+		return 0, "", fmt.Errorf("malformed line %q", line)
+	}
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 0 || i >= n {
+		return 0, "", fmt.Errorf("index out of range in %q", line)
+	}
+	return i, value, nil
+}
+
+// applyRouting applies the routes in lines. Routes to outputs locked by
+// another client are ignored, as a real device does.
+func (s *Server) applyRouting(c *client, lines []string) error {
+	routes := make([][2]int, 0, len(lines))
+	for _, line := range lines {
+		destination, value, err := parsePair(line, s.cfg.Outputs)
+		if err != nil {
+			return err
+		}
+		source, err := strconv.Atoi(value)
+		if err != nil || source < 0 || source >= s.cfg.Inputs {
+			return fmt.Errorf("input out of range in %q", line)
+		}
+		routes = append(routes, [2]int{destination, source})
+	}
+	c.write("ACK\n\n")
+
+	s.mu.Lock()
+	var changed []string
+	for _, route := range routes {
+		if owner := s.locks[route[0]]; owner != nil && owner != c {
+			continue
+		}
+		s.routing[route[0]] = route[1]
+		changed = append(changed, fmt.Sprintf("%d %d", route[0], route[1]))
+	}
+	s.mu.Unlock()
+	if len(changed) > 0 {
+		s.broadcast(videohub.BlockVideoOutputRouting, changed)
+	}
+	return nil
+}
+
+func (s *Server) applyLabels(c *client, header videohub.BlockType, labels []string, lines []string) error {
+	type change struct {
+		index int
+		label string
+	}
+	changes := make([]change, 0, len(lines))
+	for _, line := range lines {
+		i, label, err := parsePair(line, len(labels))
+		if err != nil {
+			return err
+		}
+		changes = append(changes, change{i, label})
+	}
+	c.write("ACK\n\n")
+
+	s.mu.Lock()
+	for _, ch := range changes {
+		labels[ch.index] = ch.label
+	}
+	s.mu.Unlock()
+	s.broadcast(header, lines)
+	return nil
+}
+
+func (s *Server) applyLocks(c *client, lines []string) error {
+	type change struct {
+		output int
+		state  string
+	}
+	changes := make([]change, 0, len(lines))
+	for _, line := range lines {
+		i, state, err := parsePair(line, s.cfg.Outputs)
+		if err != nil {
+			return err
+		}
+		switch state {
+		case videohub.LockOwned, videohub.LockUnlocked, videohub.LockForce:
+		default:
+			return fmt.Errorf("unknown lock state in %q", line)
+		}
+		changes = append(changes, change{i, state})
+	}
+	c.write("ACK\n\n")
+
+	s.mu.Lock()
+	var outputs []int
+	for _, ch := range changes {
+		owner := s.locks[ch.output]
+		switch {
+		case ch.state == videohub.LockOwned && owner == nil:
+			s.locks[ch.output] = c
+		case ch.state == videohub.LockUnlocked && owner == c,
+			ch.state == videohub.LockForce && owner != nil:
+			s.locks[ch.output] = nil
+		default:
+			continue
+		}
+		outputs = append(outputs, ch.output)
+	}
+	s.mu.Unlock()
+	if len(outputs) > 0 {
+		s.broadcastLocks(outputs)
+	}
+	return nil
+}
+
+func (s *Server) applyConfiguration(c *client, lines []string) error {
+	var takeMode bool
+	for _, line := range lines {
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok || key != "Take Mode" {
+			return fmt.Errorf("unsupported setting %q", line)
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		takeMode = enabled
+	}
+	c.write("ACK\n\n")
+
+	s.mu.Lock()
+	s.takeMode = takeMode
+	lines = s.configurationLines()
+	s.mu.Unlock()
+	s.broadcast(videohub.BlockConfiguration, lines)
+	return nil
+}
+
+func (s *Server) applyTakeMode(c *client, lines []string) error {
+	type change struct {
+		output  int
+		enabled bool
+	}
+	changes := make([]change, 0, len(lines))
+	for _, line := range lines {
+		i, value, err := parsePair(line, s.cfg.Outputs)
+		if err != nil {
+			return err
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("malformed take mode in %q", line)
+		}
+		changes = append(changes, change{i, enabled})
+	}
+	c.write("ACK\n\n")
+
+	s.mu.Lock()
+	outputs := make([]int, 0, len(changes))
+	for _, ch := range changes {
+		s.takeModes[ch.output] = ch.enabled
+		outputs = append(outputs, ch.output)
+	}
+	lines = s.takeModeLines(outputs)
+	s.mu.Unlock()
+	s.broadcast(videohub.BlockTakeMode, lines)
+	return nil
+}
+
+// broadcast pushes a block to every connected client. The ACK for the
+// command that caused it has already been written, so clients see the ACK
+// first, as with a real device.
+func (s *Server) broadcast(header videohub.BlockType, lines []string) {
+	var b strings.Builder
+	writeBlock(&b, header, lines)
+	message := b.String()
+	for _, c := range s.clientList() {
+		c.write(message)
+	}
+}
+
+// broadcastLocks pushes the new state of outputs to every client, which
+// differs per client depending on who holds each lock.
+func (s *Server) broadcastLocks(outputs []int) {
+	for _, c := range s.clientList() {
+		s.mu.Lock()
+		lines := s.lockLines(c, outputs)
+		s.mu.Unlock()
+		var b strings.Builder
+		writeBlock(&b, videohub.BlockVideoOutputLocks, lines)
+		c.write(b.String())
+	}
+}
+
+func (s *Server) clientList() []*client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+func (c *client) write(message string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write([]byte(message))
+	return err
+}
+
+func writeBlock(b *strings.Builder, header videohub.BlockType, lines []string) {
+	b.WriteString(string(header))
+	b.WriteString(":\n")
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+}
+
+func labelLines(labels []string) []string {
+	lines := make([]string, len(labels))
+	for i, label := range labels {
+		lines[i] = fmt.Sprintf("%d %s", i, label)
+	}
+	return lines
+}
+
+func routingLines(routing []int) []string {
+	lines := make([]string, len(routing))
+	for i, source := range routing {
+		lines[i] = fmt.Sprintf("%d %d", i, source)
+	}
+	return lines
+}
@@ -0,0 +1,141 @@
+package simulator
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/StechLabs/pydeohub/videohub"
+)
+
+// TestDumpBeforeBroadcasts connects clients while the routing keeps
+// changing. Each must get the preamble first and end up with the final
+// routing.
+func TestDumpBeforeBroadcasts(t *testing.T) {
+	s := New(Config{Inputs: 8, Outputs: 8})
+	if err := s.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	host, port, _ := net.SplitHostPort(s.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	stop := make(chan struct{})
+	changing := make(chan struct{})
+	go func() {
+		defer close(changing)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			s.Route(i%8, (i/8)%8)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	hubs := make([]*videohub.Videohub, 8)
+	for i := range hubs {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", s.Addr().String())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil || line != "PROTOCOL PREAMBLE:\n" {
+				t.Errorf("first line %q, %v; want the preamble", line, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			vh, err := videohub.NewVideohubWithOptions(host, videohub.WithPort(p), videohub.WithLogger(nil))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			hubs[i] = vh
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	<-changing
+	s.Route(0, 7)
+
+	want := s.Routing()
+	for i, vh := range hubs {
+		if vh == nil {
+			continue
+		}
+		defer vh.Close()
+		deadline := time.Now().Add(2 * time.Second)
+		for !reflect.DeepEqual(vh.Routing(), want) && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if got := vh.Routing(); !reflect.DeepEqual(got, want) {
+			t.Errorf("client %d: Routing() = %v, want %v", i, got, want)
+		}
+	}
+}
+
+// waitFor reports whether cond became true within a second.
+func waitFor(cond func() bool) bool {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
+
+func TestTakeMode(t *testing.T) {
+	s := New(Config{Inputs: 4, Outputs: 4})
+	if err := s.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	host, port, _ := net.SplitHostPort(s.Addr().String())
+	p, _ := strconv.Atoi(port)
+	connect := func() *videohub.Videohub {
+		vh, err := videohub.NewVideohubWithOptions(host, videohub.WithPort(p), videohub.WithLogger(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { vh.Close() })
+		if err := vh.WaitForReady(5 * time.Second); err != nil {
+			t.Fatal(err)
+		}
+		return vh
+	}
+	vh, other := connect(), connect()
+
+	// TAKE MODE follows the blocks WaitForReady waits for.
+	want := []bool{false, false, false, false}
+	if !waitFor(func() bool { return reflect.DeepEqual(vh.TakeModes(), want) }) {
+		t.Fatalf("TakeModes() from the dump = %v, want %v", vh.TakeModes(), want)
+	}
+	if err := vh.SetTakeMode(2, true); err != nil {
+		t.Fatalf("SetTakeMode: %v", err)
+	}
+	want = []bool{false, false, true, false}
+	if got := s.TakeModes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("simulator TakeModes() = %v, want %v", got, want)
+	}
+	if !waitFor(func() bool { return reflect.DeepEqual(other.TakeModes(), want) }) {
+		t.Errorf("other client TakeModes() = %v, want %v", other.TakeModes(), want)
+	}
+	late := connect()
+	if !waitFor(func() bool { return reflect.DeepEqual(late.TakeModes(), want) }) {
+		t.Errorf("TakeModes() after connecting = %v, want %v", late.TakeModes(), want)
+	}
+}
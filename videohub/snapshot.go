@@ -0,0 +1,246 @@
+package videohub
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Snapshot is a copy of the state of a Videohub, suitable for marshalling to
+// JSON for backups or for showing the last known state of an offline device.
+type Snapshot struct {
+	ProtocolVersion string   `json:"protocolVersion"`
+	Model           string   `json:"model"`
+	UniqueID        string   `json:"uniqueId"`
+	Inputs          int      `json:"inputs"`
+	Outputs         int      `json:"outputs"`
+	InputLabels     []string `json:"inputLabels"`
+	OutputLabels    []string `json:"outputLabels"`
+	Routing         []int    `json:"routing"`             // Input routed to each output, -1 if unknown
+	Locks           []string `json:"locks,omitempty"`     // Lock of each output: "none", "owned" or "locked"; nil if not reported
+	TakeMode        *bool    `json:"takeMode,omitempty"`  // Global Take Mode, nil if not reported
+	TakeModes       []bool   `json:"takeModes,omitempty"` // Per-output Take Mode, nil if not reported
+}
+
+// Snapshot returns a consistent copy of the current state.
+func (vh *Videohub) Snapshot() Snapshot {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	snap := Snapshot{
+		ProtocolVersion: vh.protocolVersion,
+		Model:           vh.model,
+		UniqueID:        vh.uniqueID,
+		Inputs:          vh.inputs,
+		Outputs:         vh.outputs,
+		InputLabels:     append([]string{}, vh.inputLabels...),
+		OutputLabels:    append([]string{}, vh.outputLabels...),
+		Routing:         append([]int{}, vh.routing...),
+	}
+	if vh.locksSeen {
+		snap.Locks = make([]string, len(vh.locks))
+		for o, state := range vh.locks {
+			snap.Locks[o] = snapshotLock(state)
+		}
+	}
+	if vh.takeModeSeen {
+		takeMode := vh.takeMode
+		snap.TakeMode = &takeMode
+	}
+	if vh.takeModes != nil {
+		snap.TakeModes = append([]bool{}, vh.takeModes...)
+	}
+	return snap
+}
+
+// Snapshot lock names, stable for consumers of the JSON.
+const (
+	snapshotLockNone   = "none"
+	snapshotLockOwned  = "owned"
+	snapshotLockLocked = "locked"
+)
+
+// snapshotLock names a lock state from the VIDEO OUTPUT LOCKS block.
+func snapshotLock(state string) string {
+	switch state {
+	case LockOwned:
+		return snapshotLockOwned
+	case LockLocked:
+		return snapshotLockLocked
+	}
+	return snapshotLockNone
+}
+
+// snapshotLocked reports whether a snapshot lock name, or a raw lock state as
+// saved by earlier versions, means the output was locked.
+func snapshotLocked(lock string) bool {
+	switch lock {
+	case snapshotLockOwned, snapshotLockLocked, LockOwned, LockLocked:
+		return true
+	}
+	return false
+}
+
+// ApplySnapshot pushes the labels and routing saved in snap back to the device
+// and waits for it to acknowledge them. It is Restore limited to labels and
+// routing.
+func (vh *Videohub) ApplySnapshot(snap Snapshot) error {
+	return vh.Restore(snap, WithLabelsOnly(), WithRoutingOnly())
+}
+
+type restoreConfig struct {
+	only    bool // Restore just the parts selected below
+	labels  bool
+	routing bool
+}
+
+// RestoreOption narrows what Restore applies. Given several, Restore applies
+// the union of what they select.
+type RestoreOption func(*restoreConfig)
+
+// WithLabelsOnly makes Restore apply the input and output labels.
+func WithLabelsOnly() RestoreOption {
+	return func(c *restoreConfig) {
+		c.only = true
+		c.labels = true
+	}
+}
+
+// WithRoutingOnly makes Restore apply the routing.
+func WithRoutingOnly() RestoreOption {
+	return func(c *restoreConfig) {
+		c.only = true
+		c.routing = true
+	}
+}
+
+// Restore pushes the state saved in snap back to the device and waits for it
+// to acknowledge it: labels, routing, Take Mode and locks, or only what opts
+// select. The snapshot must have been taken from a device with the same number
+// of inputs and outputs. Unknown routes (-1) are skipped.
+//
+// Outputs that were locked when the snapshot was taken are locked by this
+// client, and outputs this client holds that were unlocked are released.
+// Locks held by other clients are never broken: routes to those outputs are
+// left out, everything else is restored, and the error wraps ErrOutputLocked
+// and lists the skipped outputs.
+func (vh *Videohub) Restore(snap Snapshot, opts ...RestoreOption) error {
+	var cfg restoreConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	all := !cfg.only
+
+	inputs, outputs, ready := vh.Dimensions()
+	if !ready {
+		return ErrNotReady
+	}
+	if snap.Inputs != inputs || snap.Outputs != outputs {
+		return fmt.Errorf("videohub: snapshot is %dx%d but device is %dx%d", snap.Inputs, snap.Outputs, inputs, outputs)
+	}
+
+	var command string
+	labelBlock := func(header string, labels []string, count int) error {
+		if len(labels) > count {
+			return fmt.Errorf("videohub: snapshot has %d %s but device has %d", len(labels), strings.ToLower(header), count)
+		}
+		var lines []string
+		for i, label := range labels {
+			if err := checkLabel(label, vh.labelCharset); err != nil {
+				return err
+			}
+			lines = append(lines, fmt.Sprintf("%d %s", i, label))
+		}
+		if len(lines) > 0 {
+			command += buildBlock(header, lines)
+		}
+		return nil
+	}
+	if all || cfg.labels {
+		if err := labelBlock("INPUT LABELS", snap.InputLabels, inputs); err != nil {
+			return err
+		}
+		if err := labelBlock("OUTPUT LABELS", snap.OutputLabels, outputs); err != nil {
+			return err
+		}
+	}
+
+	var locked []int
+	if all || cfg.routing {
+		var routes [][2]int
+		for destination, source := range snap.Routing {
+			if source < 0 {
+				continue
+			}
+			if destination >= outputs || source >= inputs {
+				return fmt.Errorf("videohub: snapshot route %d -> %d out of range", source, destination)
+			}
+			if vh.checkUnlocked(destination) != nil {
+				locked = append(locked, destination)
+				continue
+			}
+			routes = append(routes, [2]int{destination, source})
+		}
+		if len(routes) > 0 {
+			route, err := BuildBulkRouteCommand(routes)
+			if err != nil {
+				return err
+			}
+			command += route
+		}
+	}
+
+	if all {
+		if snap.TakeMode != nil {
+			command += BuildGlobalTakeModeCommand(*snap.TakeMode)
+		}
+		if snap.TakeModes != nil && vh.TakeModes() != nil {
+			if len(snap.TakeModes) > outputs {
+				return fmt.Errorf("videohub: snapshot has %d take modes but device has %d outputs", len(snap.TakeModes), outputs)
+			}
+			var lines []string
+			for destination, enabled := range snap.TakeModes {
+				lines = append(lines, fmt.Sprintf("%d %t", destination, enabled))
+			}
+			command += buildBlock(string(BlockTakeMode), lines)
+		}
+		lines, err := vh.restoreLockLines(snap.Locks, outputs)
+		if err != nil {
+			return err
+		}
+		if len(lines) > 0 {
+			command += buildBlock(string(BlockVideoOutputLocks), lines)
+		}
+	}
+
+	if command != "" {
+		if err := vh.sendSync(command); err != nil {
+			return err
+		}
+	}
+	if len(locked) > 0 {
+		return fmt.Errorf("%w: routes to outputs %v not restored", ErrOutputLocked, locked)
+	}
+	return nil
+}
+
+// restoreLockLines returns the VIDEO OUTPUT LOCKS lines that bring the
+// current locks in line with locks.
+func (vh *Videohub) restoreLockLines(locks []string, outputs int) ([]string, error) {
+	if len(locks) > outputs {
+		return nil, fmt.Errorf("videohub: snapshot has %d locks but device has %d outputs", len(locks), outputs)
+	}
+	current := vh.Locks()
+	var lines []string
+	for destination, lock := range locks {
+		state := LockUnlocked
+		if destination < len(current) {
+			state = current[destination]
+		}
+		switch locked := snapshotLocked(lock); {
+		case !locked && state == LockOwned:
+			lines = append(lines, fmt.Sprintf("%d %s", destination, LockUnlocked))
+		case locked && state == LockUnlocked:
+			lines = append(lines, fmt.Sprintf("%d %s", destination, LockOwned))
+		}
+	}
+	return lines, nil
+}
@@ -0,0 +1,94 @@
+package videohub
+
+import (
+	"strings"
+)
+
+// With Take Mode enabled, crosspoint changes made on a control panel only
+// take effect once confirmed with the panel's Take button.
+
+// GlobalTakeMode reports whether Take Mode is enabled for the whole device, as
+// reported in the CONFIGURATION block.
+func (vh *Videohub) GlobalTakeMode() bool {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return vh.takeMode
+}
+
+// TakeModes returns a copy of the Take Mode setting of each output, or nil if
+// the device does not report Take Mode per output.
+func (vh *Videohub) TakeModes() []bool {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	if vh.takeModes == nil {
+		return nil
+	}
+	return append([]bool{}, vh.takeModes...)
+}
+
+// TakeMode reports whether Take Mode is enabled for destination. On devices
+// that do not report Take Mode per output this is the global setting.
+func (vh *Videohub) TakeMode(destination int) bool {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	if destination >= 0 && destination < len(vh.takeModes) {
+		return vh.takeModes[destination]
+	}
+	return vh.takeMode
+}
+
+// SetTakeMode turns Take Mode on or off for destination.
+func (vh *Videohub) SetTakeMode(destination int, enabled bool) error {
+	if err := vh.checkOutput(destination); err != nil {
+		return err
+	}
+	return vh.sendBuilt(BuildTakeModeCommand(destination, enabled))
+}
+
+// SetGlobalTakeMode turns Take Mode on or off for the whole device.
+func (vh *Videohub) SetGlobalTakeMode(enabled bool) error {
+	return vh.sendSync(BuildGlobalTakeModeCommand(enabled))
+}
+
+func (vh *Videohub) processConfiguration(contents []string) {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	for _, item := range contents {
+		parts := strings.Split(item, ": ")
+		if len(parts) == 2 && parts[0] == "Take Mode" {
+			vh.takeModeSeen = true
+			vh.takeMode = parseBool(parts[1])
+		}
+	}
+}
+
+func (vh *Videohub) processTakeMode(contents []string) {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	vh.takeModeSeen = true
+	if vh.takeModes == nil {
+		vh.takeModes = make([]bool, vh.outputs)
+	}
+	limit := vh.portLimit(vh.outputs)
+	for _, item := range contents {
+		parts := strings.Split(item, " ")
+		if len(parts) != 2 {
+			continue
+		}
+		o, ok := parseIndex(parts[0])
+		if !ok || o >= limit {
+			continue
+		}
+		vh.takeModes = growTakeModes(vh.takeModes, o+1)
+		vh.takeModes[o] = parseBool(parts[1])
+	}
+}
+
+// growTakeModes returns takeModes extended to at least n entries, keeping the
+// existing ones.
+func growTakeModes(takeModes []bool, n int) []bool {
+	if len(takeModes) >= n {
+		return takeModes
+	}
+	return append(takeModes, make([]bool, n-len(takeModes))...)
+}
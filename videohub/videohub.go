@@ -2,124 +2,517 @@ package videohub
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"maps"
 	"net"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 )
 
+// ConnectionState describes the state of the TCP link to the Videohub.
+type ConnectionState int
+
+const (
+	StateDisconnected ConnectionState = iota
+	StateConnecting
+	StateConnected
+	StateReconnecting
+	StateFailed
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("ConnectionState(%d)", int(s))
+}
+
 type Videohub struct {
-	ip              string
-	conn            net.Conn
-	logger          *log.Logger
-	readerThread    *sync.WaitGroup
-	protocolVersion string // Videohub Ethernet Protocol Version (ex. '2.7')
-	model           string // Model of Videohub (ex. 'Blackmagic Smart Videohub 20 x 20')
-	uniqueID        string // Generated unique identifier for each Videohub, persists across boots and network changes. (ex. '7C2E0DA4BFC0' )
-	inputs          int    // Number of Video Inputs (sources)
-	outputs         int    // Number of Video Outputs (destinations)
-	inputLabels     []string
-	outputLabels    []string
-	routing         []int
-}
-
-func NewVideohub(ip string) *Videohub {
+	ip                 string
+	port               int
+	dialTimeout        time.Duration
+	dialer             DialFunc
+	commandTimeout     time.Duration
+	writeTimeout       time.Duration
+	maxRetries         int           // Reconnect attempts before giving up, 0 for no limit
+	keepaliveInterval  time.Duration // Idle time before sending PING, 0 to disable
+	allowForceUnlock   bool          // Set by WithAllowForceUnlock
+	relock             bool          // Set by WithRelockOnReconnect
+	labelCharset       LabelCharset  // Set by WithLabelCharset, nil allows any label
+	lastSeen           atomic.Int64  // Unix nanoseconds of the last line received
+	conn               net.Conn
+	logger             *slog.Logger
+	logLevel           slog.Level
+	recorder           *recorder // Set by WithRecorder
+	wireCapture        io.Writer // Set by WithWireCapture
+	readerThread       *sync.WaitGroup
+	readerID           atomic.Uint64 // Goroutine running reader, so that Close can tell when a handler calls it
+	connMu             sync.Mutex
+	sendMu             sync.Mutex // Serializes writes so responses can be matched in order
+	pendingMu          sync.Mutex
+	pending            []*pendingCommand
+	metrics            metrics
+	ctx                context.Context // Cancelled by Close to stop the reader and any dial in progress
+	cancel             context.CancelFunc
+	closeOnce          sync.Once
+	ready              chan struct{} // Closed once the initial state dump is complete
+	readyOnce          sync.Once
+	handlersMu         sync.Mutex
+	state              ConnectionState
+	stateHandlers      []func(old, new ConnectionState)
+	connectHandlers    []func()
+	disconnectHandlers []func(err error)
+	deviceHandlers     []func(oldID, newID string)
+	powerHandlers      []func(ps PowerSupply)
+	dimensionHandlers  []func(oldInputs, oldOutputs, inputs, outputs int)
+	routeHandlers      []func(destination, source int)
+	labelHandlers      []func(kind LabelKind, index int, label string)
+	blockHandlers      map[BlockType][]func(lines []string)
+	subscribers        map[chan Event]struct{}
+	mu                 sync.RWMutex
+	protocolVersion    string // Videohub Ethernet Protocol Version (ex. '2.7')
+	model              string // Model of Videohub (ex. 'Blackmagic Smart Videohub 20 x 20')
+	uniqueID           string // Generated unique identifier for each Videohub, persists across boots and network changes. (ex. '7C2E0DA4BFC0' )
+	friendlyName       string // User-assigned device name, reported by protocol 2.8 and later (ex. 'Studio A')
+	inputs             int    // Number of Video Inputs (sources)
+	outputs            int    // Number of Video Outputs (destinations)
+	cleanSwitch        bool   // Device advertises glitch-free switching between matched-format sources
+	monitorOutputs     int    // Number of Video Monitoring Outputs
+	serialPorts        int    // Number of RS-422 Serial Ports
+	processingUnits    int    // Number of Video Processing Units
+	takeModeSeen       bool   // CONFIGURATION block reported a Take Mode setting
+	takeMode           bool   // Global Take Mode from the CONFIGURATION block
+	locksSeen          bool   // VIDEO OUTPUT LOCKS block was received
+	deviceReady        bool   // VIDEOHUB DEVICE block has been parsed
+	inputLabels        []string
+	outputLabels       []string
+	inputLabelsSeen    bool // INPUT LABELS dump received, later blocks are changes
+	outputLabelsSeen   bool // OUTPUT LABELS dump received, later blocks are changes
+	routing            []int
+	history            map[int]*routeRing // Recent route changes per output
+	historySize        int
+	locks              []string
+	takeModes          []bool // Per-output Take Mode, nil if the device does not report it
+	monitorLabels      []string
+	monitorLabelsSeen  bool
+	monitorRouting     []int
+	serialLabels       []string
+	serialLabelsSeen   bool
+	serialRouting      []int
+	serialDirections   []SerialDirection
+	serialLocks        []string
+	processingRouting  []int
+	frameLabels        []string
+	frameLabelsSeen    bool
+	frameRouting       []int
+	alarms             []Alarm
+	powerSupplies      []PowerSupply
+	lastBlocks         map[BlockType]rawBlock
+	deviceFields       map[string]string  // Device block fields not parsed into the fields above
+	dumpSeen           map[BlockType]bool // Initial dump blocks received since the last connect
+	stale              bool               // Disconnected since the last complete dump
+	relockOutputs      []int              // Outputs to lock again once the dump after a reconnect is complete
+	relockID           string             // Unique ID of the device relockOutputs were locked on
+}
+
+// NewVideohub connects to the Videohub at ip on the default port.
+func NewVideohub(ip string) (*Videohub, error) {
+	return NewVideohubWithOptions(ip)
+}
+
+// NewVideohubWithConn runs the protocol over an already established
+// connection, such as one end of net.Pipe or a TLS or SSH tunnelled stream.
+// Reconnects use the dialer given with WithDialer; without one the Videohub
+// keeps retrying unsuccessfully once conn fails, until it is closed.
+func NewVideohubWithConn(conn net.Conn, opts ...Option) (*Videohub, error) {
+	withConn := func(vh *Videohub) {
+		next, used := vh.dialer, false
+		vh.dialer = func(ctx context.Context, addr string) (net.Conn, error) {
+			if !used {
+				used = true
+				return conn, nil
+			}
+			if next != nil {
+				return next(ctx, addr)
+			}
+			return nil, errors.New("videohub: no dialer to replace the connection given to NewVideohubWithConn")
+		}
+	}
+	// Reconnects dial the host and port conn is connected to, unless opts
+	// say otherwise.
+	host := conn.RemoteAddr().String()
+	var hostOpts []Option
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host = h
+		if port, err := strconv.Atoi(p); err == nil {
+			hostOpts = append(hostOpts, WithPort(port))
+		}
+	}
+	return NewVideohubWithOptions(host, append(append(hostOpts, opts...), withConn)...)
+}
+
+// NewVideohubWithOptions connects to the Videohub at ip, configured by opts.
+func NewVideohubWithOptions(ip string, opts ...Option) (*Videohub, error) {
+	return NewVideohubContext(context.Background(), ip, opts...)
+}
+
+// NewVideohubContext connects to the Videohub at ip, configured by opts. ctx
+// bounds the initial connection attempt only; use Close to shut the Videohub
+// down afterwards.
+func NewVideohubContext(ctx context.Context, ip string, opts ...Option) (*Videohub, error) {
 	vh := &Videohub{
-		ip:     ip,
-		logger: log.New(os.Stderr, "", log.LstdFlags),
+		ip:             ip,
+		port:           DefaultPort,
+		commandTimeout: DefaultCommandTimeout,
+		historySize:    DefaultHistorySize,
+		logger:         slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		readerThread:   &sync.WaitGroup{},
+		ready:          make(chan struct{}),
+	}
+	vh.ctx, vh.cancel = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(vh)
+	}
+	vh.logger = slog.New(levelHandler{vh.logLevel, vh.logger.Handler()}).With("videohub", ip)
+	vh.setState(StateConnecting)
+	if err := vh.connect(ctx); err != nil {
+		vh.setState(StateFailed)
+		vh.cancel()
+		return nil, err
 	}
-	vh.connect()
-	vh.readerThread = &sync.WaitGroup{}
 	vh.readerThread.Add(1)
 	go vh.reader()
-	return vh
+	if vh.keepaliveInterval > 0 {
+		go vh.keepalive()
+	}
+	return vh, nil
 }
 
-func (vh *Videohub) connect() {
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:9990", vh.ip))
+// connect dials the device, giving up when ctx is done or the Videohub is
+// closed.
+func (vh *Videohub) connect(ctx context.Context) error {
+	addr := net.JoinHostPort(vh.ip, strconv.Itoa(vh.port))
+	conn, err := vh.dial(ctx, addr)
 	if err != nil {
-		vh.logger.Fatalf("Failed to connect to Videohub: %v", err)
+		if vh.closed() {
+			return ErrClosed
+		}
+		return fmt.Errorf("videohub: failed to connect to %s: %w", addr, err)
+	}
+	vh.connMu.Lock()
+	if vh.closed() {
+		vh.connMu.Unlock()
+		conn.Close()
+		return ErrClosed
 	}
 	vh.conn = conn
+	vh.connMu.Unlock()
+	vh.setState(StateConnected)
+	vh.emitConnect()
+	return nil
+}
+
+func (vh *Videohub) dial(ctx context.Context, addr string) (net.Conn, error) {
+	ctx, stop := vh.withLifetime(ctx)
+	defer stop()
+	if vh.dialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, vh.dialTimeout)
+		defer cancel()
+	}
+	if vh.dialer != nil {
+		return vh.dialer(ctx, addr)
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", addr)
+}
+
+// withLifetime returns a context that is also cancelled when the Videohub is
+// closed.
+func (vh *Videohub) withLifetime(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(vh.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// ErrClosed is returned when the Videohub has been shut down with Close.
+var ErrClosed = errors.New("videohub: closed")
+
+// Close disconnects from the Videohub and waits for the reader goroutine to
+// exit. It does not attempt to reconnect and is safe to call more than once.
+// Handlers may call it too: as they run on the reader goroutine, Close then
+// returns without waiting, and the reader exits once the handler returns.
+func (vh *Videohub) Close() error {
+	err := ErrClosed
+	vh.closeOnce.Do(func() {
+		wasConnected := vh.connectionState() == StateConnected
+		vh.connMu.Lock()
+		vh.cancel()
+		err = nil
+		if vh.conn != nil {
+			err = vh.conn.Close()
+		}
+		vh.connMu.Unlock()
+		if goroutineID() != vh.readerID.Load() {
+			vh.readerThread.Wait()
+		}
+		vh.failPending(ErrClosed)
+		vh.setState(StateDisconnected)
+		if wasConnected {
+			vh.emitDisconnect(nil)
+		}
+		vh.closeSubscribers()
+	})
+	return err
+}
+
+// goroutineID returns the ID of the calling goroutine, as shown in stack
+// traces.
+func goroutineID() uint64 {
+	var buf [32]byte
+	n := runtime.Stack(buf[:], false)
+	id, _, _ := strings.Cut(strings.TrimPrefix(string(buf[:n]), "goroutine "), " ")
+	n64, _ := strconv.ParseUint(id, 10, 64)
+	return n64
+}
+
+func (vh *Videohub) closed() bool {
+	return vh.ctx.Err() != nil
+}
+
+func (vh *Videohub) currentConn() net.Conn {
+	vh.connMu.Lock()
+	defer vh.connMu.Unlock()
+	return vh.conn
+}
+
+// OnConnectionChange registers fn to be called whenever the connection state
+// changes. Handlers run on the goroutine that observed the transition. The
+// initial connect happens in the constructor, before fn can be registered;
+// use WithConnectionHandler to see it too.
+func (vh *Videohub) OnConnectionChange(fn func(old, new ConnectionState)) {
+	vh.handlersMu.Lock()
+	defer vh.handlersMu.Unlock()
+	vh.stateHandlers = append(vh.stateHandlers, fn)
+}
+
+// WithConnectionHandler registers fn as with OnConnectionChange before
+// connecting, so that it also sees the initial connecting and connected
+// transitions.
+func WithConnectionHandler(fn func(old, new ConnectionState)) Option {
+	return func(vh *Videohub) {
+		vh.OnConnectionChange(fn)
+	}
+}
+
+// OnDeviceChange registers fn to be called when the device answering at the
+// configured address reports a different unique ID than before, for example
+// after a reconnect lands on a replacement unit. All cached state has already
+// been discarded when fn runs.
+func (vh *Videohub) OnDeviceChange(fn func(oldID, newID string)) {
+	vh.handlersMu.Lock()
+	defer vh.handlersMu.Unlock()
+	vh.deviceHandlers = append(vh.deviceHandlers, fn)
+}
+
+// OnDimensionsChange registers fn to be called when a device block reports a
+// different number of inputs or outputs than the previous one. By then the
+// labels and routing have been resized: overlapping entries are kept and the
+// rest are dropped.
+func (vh *Videohub) OnDimensionsChange(fn func(oldInputs, oldOutputs, inputs, outputs int)) {
+	vh.handlersMu.Lock()
+	defer vh.handlersMu.Unlock()
+	vh.dimensionHandlers = append(vh.dimensionHandlers, fn)
+}
+
+func (vh *Videohub) connectionState() ConnectionState {
+	vh.handlersMu.Lock()
+	defer vh.handlersMu.Unlock()
+	return vh.state
+}
+
+func (vh *Videohub) setState(state ConnectionState) {
+	vh.handlersMu.Lock()
+	old := vh.state
+	if old == state {
+		vh.handlersMu.Unlock()
+		return
+	}
+	vh.state = state
+	handlers := append([]func(old, new ConnectionState){}, vh.stateHandlers...)
+	vh.handlersMu.Unlock()
+	for _, fn := range handlers {
+		fn(old, state)
+	}
+	vh.publish(ConnectionChange{Old: old, New: state})
 }
 
+// reader runs the protocol on the current connection until it fails, then
+// reconnects and carries on.
 func (vh *Videohub) reader() {
 	defer vh.readerThread.Done()
-	reader := bufio.NewReader(vh.conn)
+	vh.readerID.Store(goroutineID())
+	for {
+		var r io.Reader = vh.currentConn()
+		if vh.wireCapture != nil {
+			r = io.TeeReader(r, vh.wireCapture)
+		}
+		err := frameBlocks(r, vh.received, vh.decodeMessage, vh.decodeResponse)
+		if !vh.handleReadError(err) {
+			return
+		}
+	}
+}
+
+// frameBlocks splits the stream r into blocks until reading fails, and
+// returns the read error. A block starts with a header line ending in ':' and
+// runs until the next blank line; any other non-empty line outside a block
+// (ex. 'ACK') is a response to a command. received, if not nil, sees every
+// line first, without its line ending.
+func frameBlocks(r io.Reader, received func(line string), block func(lines []string), response func(line string)) error {
+	reader := bufio.NewReader(r)
+	var lines []string
 	for {
-		message, err := reader.ReadBytes('\n')
+		line, err := reader.ReadString('\n')
 		if err != nil {
-			vh.logger.Printf("Error reading from Videohub: %v", err)
-			vh.reconnect()
-			continue
-		}
-		messageStr := string(message)
-		if strings.HasSuffix(messageStr, ":\n") {
-			message, err = reader.ReadBytes('\n')
-			if err != nil {
-				vh.logger.Printf("Error reading from Videohub: %v", err)
-				vh.reconnect()
-				continue
-			}
-			vh.decodeMessage(append(message[:len(message)-1], message...))
-		} else {
-			vh.decodeResponse(message[:len(message)-1])
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if received != nil {
+			received(line)
+		}
+		switch {
+		case lines != nil && line == "":
+			block(lines)
+			lines = nil
+		case lines != nil:
+			lines = append(lines, line)
+		case strings.HasSuffix(line, ":"):
+			lines = []string{line}
+		case line != "":
+			response(line)
 		}
 	}
 }
 
-func (vh *Videohub) reconnect() {
-	vh.logger.Println("Reconnecting to Videohub...")
-	vh.conn.Close()
-	vh.connect()
+// received notes a line read from the device.
+func (vh *Videohub) received(line string) {
+	vh.touch()
+	vh.recorder.record('<', line)
 }
 
-func (vh *Videohub) send(command string) {
-	vh.logger.Printf("Sending Message: [%s]", strings.ReplaceAll(command, "\n", "-"))
-	_, err := vh.conn.Write([]byte(command + "\n\n"))
-	if err != nil {
-		vh.logger.Printf("Error sending command to Videohub: %v", err)
-		vh.reconnect()
+// handleReadError reports whether the reader should keep going on a fresh
+// connection after err. A read error caused by Close is not treated as a
+// dropped connection.
+func (vh *Videohub) handleReadError(err error) bool {
+	if vh.closed() {
+		return false
+	}
+	vh.logger.Warn("Connection lost", "error", err)
+	vh.failPending(ErrConnectionLost)
+	vh.emitDisconnect(err)
+	if err := vh.reconnect(); err != nil {
+		if !errors.Is(err, ErrClosed) {
+			vh.logger.Error("Giving up on Videohub", "error", err)
+		}
+		return false
 	}
+	return true
 }
 
-func (vh *Videohub) decodeMessage(message []byte) {
-	msg := strings.TrimSuffix(string(message), "\n\n")
-	vh.logger.Printf("Received Message: [%s]", strings.ReplaceAll(msg, "\n", "//"))
-	lines := strings.Split(msg, "\n")
+func (vh *Videohub) decodeMessage(lines []string) {
+	vh.logger.Debug("Received block", "block", strings.Join(lines, "//"))
 	vh.responseProcessor(lines)
 }
 
-func (vh *Videohub) decodeResponse(message []byte) {
-	response := string(message)
-	vh.logger.Printf("Received Response: [%s]", strings.ReplaceAll(response, "\n", "//"))
+func (vh *Videohub) decodeResponse(response string) {
+	vh.logger.Debug("Received response", "response", response)
+	switch response {
+	case "ACK":
+		vh.metrics.acks.Add(1)
+		vh.resolvePending(nil)
+	case "NAK":
+		vh.metrics.naks.Add(1)
+		vh.resolvePending(ErrNAK)
+	}
 }
 
 func (vh *Videohub) responseProcessor(message []string) {
-	messageType := strings.TrimSuffix(message[0], ":")
+	messageType := BlockType(strings.TrimSuffix(message[0], ":"))
 	contents := message[1:]
+	vh.storeBlock(messageType, message[0], contents)
 	switch messageType {
-	case "PROTOCOL PREAMBLE":
+	case BlockProtocolPreamble:
 		vh.processProtocolPreamble(contents)
-	case "VIDEOHUB DEVICE":
+	case BlockVideohubDevice:
 		vh.processVideohubDevice(contents)
-	case "INPUT LABELS":
-		vh.processInputLabels(contents)
-	case "OUTPUT LABELS":
-		vh.processOutputLabels(contents)
-	case "VIDEO OUTPUT LOCKS":
-		// Do nothing
-	case "VIDEO OUTPUT ROUTING":
+	case BlockInputLabels:
+		vh.processLabels(LabelInput, contents)
+	case BlockOutputLabels:
+		vh.processLabels(LabelOutput, contents)
+	case BlockVideoOutputLocks:
+		vh.processOutputLocks(contents)
+	case BlockVideoOutputRouting:
 		vh.processOutputRouting(contents)
-	case "CONFIGURATION":
-		// Do nothing
+	case BlockMonitoringOutputLabels:
+		vh.processLabels(LabelMonitoringOutput, contents)
+	case BlockMonitoringOutputRouting:
+		vh.processMonitoringRouting(contents)
+	case BlockSerialPortLabels:
+		vh.processLabels(LabelSerialPort, contents)
+	case BlockSerialPortRouting:
+		vh.processSerialRouting(contents)
+	case BlockSerialPortDirections:
+		vh.processSerialDirections(contents)
+	case BlockSerialPortLocks:
+		vh.processSerialLocks(contents)
+	case BlockConfiguration:
+		vh.processConfiguration(contents)
+	case BlockTakeMode:
+		vh.processTakeMode(contents)
+	case BlockAlarmStatus:
+		vh.processAlarmStatus(contents)
+	case BlockProcessingUnitRouting:
+		vh.processProcessingUnitRouting(contents)
+	case BlockFrameLabels:
+		vh.processLabels(LabelFrame, contents)
+	case BlockFrameBufferRouting:
+		vh.processFrameBufferRouting(contents)
+	case BlockEndPrelude:
+	default:
+		if !vh.hasBlockHandler(messageType) {
+			vh.logger.Debug("Ignoring unknown block", "block", messageType)
+		}
 	}
+	vh.runBlockHandlers(messageType, contents)
+	vh.markDumpBlock(messageType)
 }
 
 func (vh *Videohub) processProtocolPreamble(contents []string) {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
 	for _, item := range contents {
 		parts := strings.Split(item, ": ")
 		if len(parts) == 2 {
@@ -132,6 +525,19 @@ func (vh *Videohub) processProtocolPreamble(contents []string) {
 }
 
 func (vh *Videohub) processVideohubDevice(contents []string) {
+	vh.mu.Lock()
+	oldID, newID := vh.uniqueID, ""
+	for _, item := range contents {
+		if value, ok := strings.CutPrefix(item, "Unique ID: "); ok {
+			newID = value
+		}
+	}
+	changed := oldID != "" && newID != "" && oldID != newID
+	if changed {
+		vh.invalidateState()
+	}
+	wasReady, oldInputs, oldOutputs := vh.deviceReady, vh.inputs, vh.outputs
+	vh.deviceReady = true
 	for _, item := range contents {
 		parts := strings.Split(item, ": ")
 		if len(parts) == 2 {
@@ -139,74 +545,561 @@ func (vh *Videohub) processVideohubDevice(contents []string) {
 			switch key {
 			case "Model name":
 				vh.model = value
+			case "Friendly name":
+				vh.friendlyName = value
 			case "Unique ID":
 				vh.uniqueID = value
+			case "Clean switch":
+				vh.cleanSwitch = parseBool(value)
+			case "Video monitoring outputs", "Monitoring outputs":
+				vh.monitorOutputs = parseCount(value)
+			case "Serial ports":
+				vh.serialPorts = parseCount(value)
+			case "Video processing units":
+				vh.processingUnits = parseCount(value)
 			case "Video inputs":
-				vh.inputs = parseInt(value)
-				vh.inputLabels = make([]string, vh.inputs)
+				vh.inputs = parseCount(value)
 			case "Video outputs":
-				vh.outputs = parseInt(value)
-				vh.outputLabels = make([]string, vh.outputs)
-				vh.routing = make([]int, vh.outputs)
-				for i := range vh.routing {
-					vh.routing[i] = -1
+				vh.outputs = parseCount(value)
+			case "Device present":
+				// Seen in every device block, nothing to keep.
+			default:
+				if vh.deviceFields == nil {
+					vh.deviceFields = make(map[string]string)
 				}
+				vh.deviceFields[key] = value
 			}
 		}
 	}
+	vh.resizeState()
+	inputs, outputs := vh.inputs, vh.outputs
+	vh.mu.Unlock()
+
+	if wasReady && (inputs != oldInputs || outputs != oldOutputs) {
+		vh.logger.Info("Videohub dimensions changed", "from", fmt.Sprintf("%dx%d", oldInputs, oldOutputs), "to", fmt.Sprintf("%dx%d", inputs, outputs))
+		vh.handlersMu.Lock()
+		handlers := append([]func(oldInputs, oldOutputs, inputs, outputs int){}, vh.dimensionHandlers...)
+		vh.handlersMu.Unlock()
+		for _, fn := range handlers {
+			fn(oldInputs, oldOutputs, inputs, outputs)
+		}
+		vh.publish(DimensionsChange{OldInputs: oldInputs, OldOutputs: oldOutputs, Inputs: inputs, Outputs: outputs})
+	}
+
+	if changed {
+		vh.logger.Info("Videohub identity changed", "from", oldID, "to", newID)
+		vh.handlersMu.Lock()
+		handlers := append([]func(oldID, newID string){}, vh.deviceHandlers...)
+		vh.handlersMu.Unlock()
+		for _, fn := range handlers {
+			fn(oldID, newID)
+		}
+		vh.publish(DeviceChange{OldID: oldID, NewID: newID})
+	}
 }
 
-func (vh *Videohub) processInputLabels(contents []string) {
-	for _, item := range contents {
-		parts := strings.SplitN(item, " ", 2)
-		if len(parts) == 2 {
-			i, label := parseInt(parts[0]), parts[1]
-			vh.inputLabels[i] = label
+// resizeState fits the label and routing slices to the reported dimensions.
+// Entries that still exist are kept, entries beyond the new size are dropped
+// and routes from inputs that no longer exist become unknown (-1). The caller
+// must hold vh.mu.
+func (vh *Videohub) resizeState() {
+	vh.inputLabels = growLabels(vh.inputLabels, vh.inputs)[:vh.inputs]
+	vh.outputLabels = growLabels(vh.outputLabels, vh.outputs)[:vh.outputs]
+	vh.routing = growRouting(vh.routing, vh.outputs)[:vh.outputs]
+	vh.locks = growLocks(vh.locks, vh.outputs)[:vh.outputs]
+	if vh.takeModes != nil {
+		vh.takeModes = growTakeModes(vh.takeModes, vh.outputs)[:vh.outputs]
+	}
+	vh.monitorLabels = growLabels(vh.monitorLabels, vh.monitorOutputs)[:vh.monitorOutputs]
+	vh.monitorRouting = growRouting(vh.monitorRouting, vh.monitorOutputs)[:vh.monitorOutputs]
+	vh.processingRouting = growRouting(vh.processingRouting, vh.processingUnits)[:vh.processingUnits]
+	for _, routing := range [][]int{vh.routing, vh.monitorRouting, vh.processingRouting} {
+		for o, source := range routing {
+			if source >= vh.inputs {
+				routing[o] = -1
+			}
+		}
+	}
+	vh.serialLabels = growLabels(vh.serialLabels, vh.serialPorts)[:vh.serialPorts]
+	vh.serialRouting = growRouting(vh.serialRouting, vh.serialPorts)[:vh.serialPorts]
+	vh.serialDirections = growDirections(vh.serialDirections, vh.serialPorts)[:vh.serialPorts]
+	vh.serialLocks = growLocks(vh.serialLocks, vh.serialPorts)[:vh.serialPorts]
+	for p, source := range vh.serialRouting {
+		if source >= vh.serialPorts {
+			vh.serialRouting[p] = -1
 		}
 	}
 }
 
-func (vh *Videohub) processOutputLabels(contents []string) {
+// invalidateState discards everything learned from a previous device. The
+// caller must hold vh.mu.
+func (vh *Videohub) invalidateState() {
+	vh.model = ""
+	vh.uniqueID = ""
+	vh.friendlyName = ""
+	vh.deviceFields = nil
+	vh.inputs = 0
+	vh.outputs = 0
+	vh.cleanSwitch = false
+	vh.monitorOutputs = 0
+	vh.serialPorts = 0
+	vh.processingUnits = 0
+	vh.takeModeSeen = false
+	vh.takeMode = false
+	vh.takeModes = nil
+	vh.locksSeen = false
+	vh.deviceReady = false
+	vh.inputLabels = nil
+	vh.outputLabels = nil
+	vh.inputLabelsSeen = false
+	vh.outputLabelsSeen = false
+	vh.routing = nil
+	vh.locks = nil
+	vh.monitorLabels = nil
+	vh.monitorLabelsSeen = false
+	vh.monitorRouting = nil
+	vh.serialLabels = nil
+	vh.serialLabelsSeen = false
+	vh.serialRouting = nil
+	vh.serialDirections = nil
+	vh.serialLocks = nil
+	vh.processingRouting = nil
+	vh.frameLabels = nil
+	vh.frameLabelsSeen = false
+	vh.frameRouting = nil
+	vh.alarms = nil
+	vh.powerSupplies = nil
+	vh.history = nil
+}
+
+func (vh *Videohub) processLabels(kind LabelKind, contents []string) {
+	vh.mu.Lock()
+	labels, seen, limit := &vh.inputLabels, &vh.inputLabelsSeen, vh.portLimit(vh.inputs)
+	switch kind {
+	case LabelOutput:
+		labels, seen, limit = &vh.outputLabels, &vh.outputLabelsSeen, vh.portLimit(vh.outputs)
+	case LabelMonitoringOutput:
+		labels, seen, limit = &vh.monitorLabels, &vh.monitorLabelsSeen, vh.portLimit(vh.monitorOutputs)
+	case LabelSerialPort:
+		labels, seen, limit = &vh.serialLabels, &vh.serialLabelsSeen, vh.portLimit(vh.serialPorts)
+	case LabelFrame:
+		labels, seen, limit = &vh.frameLabels, &vh.frameLabelsSeen, maxPorts // Frame count is not reported
+	}
+	var changes []LabelChange
 	for _, item := range contents {
 		parts := strings.SplitN(item, " ", 2)
 		if len(parts) == 2 {
-			o, label := parseInt(parts[0]), parts[1]
-			vh.outputLabels[o] = label
+			i, ok := parseIndex(parts[0])
+			if !ok || i >= limit {
+				continue
+			}
+			*labels = growLabels(*labels, i+1)
+			if *seen && (*labels)[i] != parts[1] {
+				changes = append(changes, LabelChange{Kind: kind, Index: i, Label: parts[1], Previous: (*labels)[i], Time: time.Now()})
+			}
+			(*labels)[i] = parts[1]
 		}
 	}
+	*seen = true
+	vh.mu.Unlock()
+	vh.emitLabelChanges(changes)
 }
 
 func (vh *Videohub) processOutputRouting(contents []string) {
+	vh.mu.Lock()
+	changes := updateRouting(&vh.routing, vh.portLimit(vh.outputs), contents)
+	vh.recordHistory(changes)
+	vh.mu.Unlock()
+	vh.emitRouteChanges(changes)
+}
+
+// updateRouting applies the lines of a routing block to routing, ignoring
+// malformed lines and destinations from limit on, and returns the
+// destinations whose previously known source changed.
+func updateRouting(routing *[]int, limit int, contents []string) []RouteChange {
+	var changes []RouteChange
 	for _, item := range contents {
 		parts := strings.Split(item, " ")
 		if len(parts) == 2 {
-			destination, source := parseInt(parts[0]), parseInt(parts[1])
-			vh.routing[destination] = source
+			destination, ok := parseIndex(parts[0])
+			if !ok || destination >= limit {
+				continue
+			}
+			source, ok := parseIndex(parts[1])
+			if !ok {
+				continue
+			}
+			*routing = growRouting(*routing, destination+1)
+			if old := (*routing)[destination]; old != -1 && old != source {
+				changes = append(changes, RouteChange{Destination: destination, Source: source, Previous: old, Time: time.Now()})
+			}
+			(*routing)[destination] = source
+		}
+	}
+	return changes
+}
+
+// ProtocolVersion returns the Videohub Ethernet Protocol version reported in
+// the preamble (ex. '2.7').
+func (vh *Videohub) ProtocolVersion() string {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return vh.protocolVersion
+}
+
+// Model returns the model name reported by the device.
+func (vh *Videohub) Model() string {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return vh.model
+}
+
+// UniqueID returns the unique identifier reported by the device.
+func (vh *Videohub) UniqueID() string {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return vh.uniqueID
+}
+
+// Inputs returns the number of video inputs (sources).
+func (vh *Videohub) Inputs() int {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return vh.inputs
+}
+
+// Outputs returns the number of video outputs (destinations).
+func (vh *Videohub) Outputs() int {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return vh.outputs
+}
+
+// InputLabels returns a copy of the input labels, indexed by input.
+func (vh *Videohub) InputLabels() []string {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return append([]string(nil), vh.inputLabels...)
+}
+
+// OutputLabels returns a copy of the output labels, indexed by output.
+func (vh *Videohub) OutputLabels() []string {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return append([]string(nil), vh.outputLabels...)
+}
+
+// Routing returns a copy of the crosspoint matrix: the input routed to each
+// output, or -1 if not yet known.
+func (vh *Videohub) Routing() []int {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return append([]int(nil), vh.routing...)
+}
+
+// RouteOf returns the input routed to output, or -1 if it is not known or
+// output is out of range.
+func (vh *Videohub) RouteOf(output int) int {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	if output < 0 || output >= len(vh.routing) {
+		return -1
+	}
+	return vh.routing[output]
+}
+
+// DeviceInfo describes a Videohub as reported in its preamble and device
+// blocks.
+type DeviceInfo struct {
+	Address           string `json:"address"` // Host the client connects to (ex. '192.168.0.150')
+	ProtocolVersion   string `json:"protocolVersion"`
+	Model             string `json:"model"`
+	UniqueID          string `json:"uniqueId"`
+	FriendlyName      string `json:"friendlyName,omitempty"`
+	Inputs            int    `json:"inputs"`
+	Outputs           int    `json:"outputs"`
+	MonitoringOutputs int    `json:"monitoringOutputs"`
+	SerialPorts       int    `json:"serialPorts"`
+	ProcessingUnits   int    `json:"processingUnits"`
+	// Fields holds device block fields this package does not know, as sent
+	// by newer firmware.
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// DeviceInfo returns a consistent copy of the device information.
+func (vh *Videohub) DeviceInfo() DeviceInfo {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return DeviceInfo{
+		Address:           vh.ip,
+		ProtocolVersion:   vh.protocolVersion,
+		Model:             vh.model,
+		UniqueID:          vh.uniqueID,
+		FriendlyName:      vh.friendlyName,
+		Inputs:            vh.inputs,
+		Outputs:           vh.outputs,
+		MonitoringOutputs: vh.monitorOutputs,
+		SerialPorts:       vh.serialPorts,
+		ProcessingUnits:   vh.processingUnits,
+		Fields:            maps.Clone(vh.deviceFields),
+	}
+}
+
+// FriendlyName returns the name given to the device by its user, or "" if
+// the device does not report one (protocol 2.7 and earlier).
+func (vh *Videohub) FriendlyName() string {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return vh.friendlyName
+}
+
+// SupportsCleanSwitch reports whether the device advertised clean switch
+// capability in its device block. It is false until the block is received.
+func (vh *Videohub) SupportsCleanSwitch() bool {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return vh.cleanSwitch
+}
+
+// Dimensions returns the number of inputs and outputs reported by the device,
+// read together so they are consistent. ready is false until the device block
+// has been received.
+func (vh *Videohub) Dimensions() (inputs, outputs int, ready bool) {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return vh.inputs, vh.outputs, vh.deviceReady
+}
+
+// Capabilities summarizes the optional features of the connected device.
+type Capabilities struct {
+	MonitoringOutputs bool `json:"monitoringOutputs"`
+	SerialPorts       bool `json:"serialPorts"`
+	ProcessingUnits   bool `json:"processingUnits"`
+	TakeMode          bool `json:"takeMode"`
+	CleanSwitch       bool `json:"cleanSwitch"`
+	Locks             bool `json:"locks"`
+	TakeModePerOutput bool `json:"takeModePerOutput"` // Take Mode is reported in a TAKE MODE block
+	FriendlyName      bool `json:"friendlyName"`      // Device block carries a Friendly name
+	EndPrelude        bool `json:"endPrelude"`        // Initial dump ends with END PRELUDE
+}
+
+// Capabilities reports what the device supports, derived from the blocks
+// received so far and, for EndPrelude, the protocol version. Locks and
+// FriendlyName are only reported once the device has sent a VIDEO OUTPUT LOCKS
+// block or a Friendly name, whatever protocol version it speaks.
+func (vh *Videohub) Capabilities() Capabilities {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	return Capabilities{
+		MonitoringOutputs: vh.monitorOutputs > 0,
+		SerialPorts:       vh.serialPorts > 0,
+		ProcessingUnits:   vh.processingUnits > 0,
+		TakeMode:          vh.takeModeSeen,
+		CleanSwitch:       vh.cleanSwitch,
+		Locks:             vh.locksSeen,
+		TakeModePerOutput: vh.takeModes != nil,
+		FriendlyName:      vh.friendlyName != "",
+		EndPrelude:        vh.dumpSeen[BlockEndPrelude] || protocolAtLeast(vh.protocolVersion, 2, 8),
+	}
+}
+
+// Route routes source to destination and waits up to the command timeout for
+// the device to acknowledge it. It returns ErrNAK if the device rejects the
+// command and ErrTimeout if it does not answer in time. Nothing is sent, and
+// ErrNotReady, ErrInputOutOfRange or ErrOutputOutOfRange is returned, until
+// the device has reported its dimensions or for ports it does not have. The
+// same applies to every other command method.
+func (vh *Videohub) Route(destination, source int) error {
+	ctx, cancel := vh.commandContext()
+	defer cancel()
+	return vh.RouteContext(ctx, destination, source)
+}
+
+// BulkRoute sends all {destination, source} pairs in routes as one block.
+func (vh *Videohub) BulkRoute(routes [][2]int) error {
+	ctx, cancel := vh.commandContext()
+	defer cancel()
+	return vh.BulkRouteContext(ctx, routes)
+}
+
+// InputLabel sets the label of input source.
+func (vh *Videohub) InputLabel(source int, label string) error {
+	ctx, cancel := vh.commandContext()
+	defer cancel()
+	return vh.InputLabelContext(ctx, source, label)
+}
+
+// OutputLabel sets the label of output destination.
+func (vh *Videohub) OutputLabel(destination int, label string) error {
+	ctx, cancel := vh.commandContext()
+	defer cancel()
+	return vh.OutputLabelContext(ctx, destination, label)
+}
+
+// sendBuilt sends the result of a Build* function and waits for the device to
+// answer, passing on any build error.
+func (vh *Videohub) sendBuilt(command string, err error) error {
+	if err != nil {
+		return err
+	}
+	return vh.sendSync(command)
+}
+
+// MaxLabelLength is the longest label, in characters, that ApplyLabelTemplate
+// will send to the device.
+const MaxLabelLength = 32
+
+// LabelTemplate generates labels from zero-based port indexes. A nil function
+// leaves the corresponding labels unchanged.
+type LabelTemplate struct {
+	Input  func(i int) string
+	Output func(i int) string
+}
+
+// ApplyLabelTemplate relabels every input and output of the device using
+// tmpl, sending one INPUT LABELS and one OUTPUT LABELS block. Nothing is sent,
+// and ErrInvalidLabel is returned, if any generated label is longer than
+// MaxLabelLength or is not a valid label.
+func (vh *Videohub) ApplyLabelTemplate(tmpl LabelTemplate) error {
+	vh.mu.RLock()
+	ready, inputs, outputs := vh.deviceReady, vh.inputs, vh.outputs
+	vh.mu.RUnlock()
+	if !ready {
+		return ErrNotReady
+	}
+
+	var command string
+	var invalid []string
+	build := func(header, kind string, count int, fn func(int) string) {
+		if fn == nil || count == 0 {
+			return
+		}
+		lines := make([]string, 0, count)
+		for i := 0; i < count; i++ {
+			label := fn(i)
+			if utf8.RuneCountInString(label) > MaxLabelLength || checkLabel(label, vh.labelCharset) != nil {
+				invalid = append(invalid, fmt.Sprintf("%s %d %q", kind, i, label))
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("%d %s", i, label))
 		}
+		command += buildBlock(header, lines)
+	}
+	build("INPUT LABELS", "input", inputs, tmpl.Input)
+	build("OUTPUT LABELS", "output", outputs, tmpl.Output)
+
+	if len(invalid) > 0 {
+		return fmt.Errorf("%w: generated by template: %s", ErrInvalidLabel, strings.Join(invalid, ", "))
+	}
+	if command == "" {
+		return nil
+	}
+	return vh.sendSync(command)
+}
+
+type clearOutputConfig struct {
+	source int
+}
+
+// ClearOutputOption configures the behaviour of ClearOutput.
+type ClearOutputOption func(*clearOutputConfig)
+
+// WithDefaultSource makes ClearOutput also route the destination to source.
+// The protocol has no "unrouted" state, so without this option the current
+// route is left untouched.
+func WithDefaultSource(source int) ClearOutputOption {
+	return func(c *clearOutputConfig) {
+		c.source = source
 	}
 }
 
-func (vh *Videohub) Route(destination, source int) {
-	vh.send(fmt.Sprintf("VIDEO OUTPUT ROUTING:\n%d %d", destination, source))
+// ClearOutput resets the label of destination to its default ("Output N")
+// and, if WithDefaultSource is given, routes it to that source. Both changes
+// are sent to the device in a single write.
+func (vh *Videohub) ClearOutput(destination int, opts ...ClearOutputOption) error {
+	cfg := clearOutputConfig{source: -1}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	vh.mu.RLock()
+	ready, inputs, outputs := vh.deviceReady, vh.inputs, vh.outputs
+	vh.mu.RUnlock()
+	if !ready {
+		return ErrNotReady
+	}
+	if destination < 0 || destination >= outputs {
+		return fmt.Errorf("%w: output %d, device has %d", ErrOutputOutOfRange, destination, outputs)
+	}
+	if cfg.source >= inputs {
+		return fmt.Errorf("%w: input %d, device has %d", ErrInputOutOfRange, cfg.source, inputs)
+	}
+
+	command, err := BuildOutputLabelCommand(destination, fmt.Sprintf("Output %d", destination+1))
+	if err != nil {
+		return err
+	}
+	if cfg.source >= 0 {
+		if err := vh.checkUnlocked(destination); err != nil {
+			return err
+		}
+		route, err := BuildRouteCommand(destination, cfg.source)
+		if err != nil {
+			return err
+		}
+		command += route
+	}
+	return vh.sendSync(command)
 }
 
-func (vh *Videohub) BulkRoute(routes [][2]int) {
-	command := "VIDEO OUTPUT ROUTING:"
-	for _, route := range routes {
-		command += fmt.Sprintf("\n%d %d", route[0], route[1])
+// maxPorts bounds how far a block may grow the state slices before the device
+// block has reported the real dimensions.
+const maxPorts = 1024
+
+// parseIndex parses a zero-based port index, rejecting anything negative,
+// malformed or beyond maxPorts.
+func parseIndex(s string) (int, bool) {
+	i, err := strconv.Atoi(s)
+	if err != nil || i < 0 || i >= maxPorts {
+		return 0, false
 	}
-	vh.send(command)
+	return i, true
 }
 
-func (vh *Videohub) InputLabel(source int, label string) {
-	vh.send(fmt.Sprintf("INPUT LABELS:\n%d %s", source, label))
+// growLabels returns labels extended to at least n entries, keeping the
+// existing ones.
+func growLabels(labels []string, n int) []string {
+	if len(labels) >= n {
+		return labels
+	}
+	return append(labels, make([]string, n-len(labels))...)
 }
 
-func (vh *Videohub) OutputLabel(destination int, label string) {
-	vh.send(fmt.Sprintf("OUTPUT LABELS:\n%d %s", destination, label))
+// growRouting returns routing extended to at least n entries, keeping the
+// existing ones and marking new destinations as unknown (-1).
+func growRouting(routing []int, n int) []int {
+	for len(routing) < n {
+		routing = append(routing, -1)
+	}
+	return routing
 }
 
 func parseInt(s string) int {
 	i, _ := strconv.Atoi(s)
 	return i
 }
+
+func parseBool(s string) bool {
+	b, _ := strconv.ParseBool(strings.TrimSpace(s))
+	return b
+}
+
+// protocolAtLeast reports whether version (ex. '2.7') is at least major.minor.
+func protocolAtLeast(version string, major, minor int) bool {
+	parts := strings.SplitN(version, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	vMajor, vMinor := parseInt(parts[0]), parseInt(parts[1])
+	return vMajor > major || (vMajor == major && vMinor >= minor)
+}
@@ -0,0 +1,753 @@
+package videohub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pipeHub returns a Videohub running over one end of a net.Pipe, and the
+// other end, on which the test plays the device.
+func pipeHub(t *testing.T, opts ...Option) (*Videohub, net.Conn) {
+	t.Helper()
+	client, device := net.Pipe()
+	vh, err := NewVideohubWithConn(client, append([]Option{WithLogger(nil)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		device.Close()
+		vh.Close()
+	})
+	return vh, device
+}
+
+// testDump is the initial state of an inputs x outputs device.
+type testDump struct {
+	inputs, outputs int
+}
+
+func (d testDump) inputLabels() []string {
+	labels := make([]string, d.inputs)
+	for i := range labels {
+		labels[i] = fmt.Sprintf("Camera %d", i+1)
+	}
+	return labels
+}
+
+func (d testDump) outputLabels() []string {
+	labels := make([]string, d.outputs)
+	for o := range labels {
+		labels[o] = fmt.Sprintf("Monitor %d", o+1)
+	}
+	return labels
+}
+
+// routing routes the outputs to the inputs in reverse, so that no route is
+// the identity.
+func (d testDump) routing() []int {
+	routing := make([]int, d.outputs)
+	for o := range routing {
+		routing[o] = (d.outputs - 1 - o) % d.inputs
+	}
+	return routing
+}
+
+// text renders the dump as the device sends it, with lines ending in eol.
+func (d testDump) text(eol string) string {
+	var lines []string
+	block := func(header string, body ...string) {
+		lines = append(append(append(lines, header+":"), body...), "")
+	}
+	block("PROTOCOL PREAMBLE", "Version: 2.7")
+	block("VIDEOHUB DEVICE",
+		"Device present: true",
+		"Model name: Blackmagic Universal Videohub 288",
+		"Unique ID: 7C2E0DA4BFC0",
+		fmt.Sprintf("Video inputs: %d", d.inputs),
+		"Video processing units: 0",
+		fmt.Sprintf("Video outputs: %d", d.outputs),
+		"Video monitoring outputs: 0",
+		"Serial ports: 0",
+	)
+	var body []string
+	for i, label := range d.inputLabels() {
+		body = append(body, fmt.Sprintf("%d %s", i, label))
+	}
+	block("INPUT LABELS", body...)
+	body = nil
+	for o, label := range d.outputLabels() {
+		body = append(body, fmt.Sprintf("%d %s", o, label))
+	}
+	block("OUTPUT LABELS", body...)
+	body = nil
+	for o, source := range d.routing() {
+		body = append(body, fmt.Sprintf("%d %d", o, source))
+	}
+	block("VIDEO OUTPUT ROUTING", body...)
+	return strings.Join(lines, eol) + eol
+}
+
+// writeChunks writes data to conn in pieces of size bytes, in the background
+// since net.Pipe blocks until the Videohub reads.
+func writeChunks(conn net.Conn, data string, size int) {
+	go func() {
+		for len(data) > 0 {
+			n := min(size, len(data))
+			if _, err := conn.Write([]byte(data[:n])); err != nil {
+				return
+			}
+			data = data[n:]
+		}
+	}()
+}
+
+func waitReady(t *testing.T, vh *Videohub) {
+	t.Helper()
+	if err := vh.WaitForReady(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// eventually fails the test if cond does not become true within a second.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDumpInSmallWrites(t *testing.T) {
+	tests := []struct {
+		name  string
+		dump  testDump
+		eol   string
+		chunk int
+	}{
+		{"40x40", testDump{40, 40}, "\n", 7},
+		{"288x288", testDump{288, 288}, "\n", 7},
+		{"288x288 CRLF", testDump{288, 288}, "\r\n", 5},
+		{"288x288 single bytes", testDump{288, 288}, "\n", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vh, device := pipeHub(t)
+			writeChunks(device, tt.dump.text(tt.eol), tt.chunk)
+			waitReady(t, vh)
+
+			if inputs, outputs, _ := vh.Dimensions(); inputs != tt.dump.inputs || outputs != tt.dump.outputs {
+				t.Errorf("Dimensions() = %dx%d, want %dx%d", inputs, outputs, tt.dump.inputs, tt.dump.outputs)
+			}
+			if got, want := vh.InputLabels(), tt.dump.inputLabels(); !reflect.DeepEqual(got, want) {
+				t.Errorf("InputLabels() = %q, want %q", got, want)
+			}
+			if got, want := vh.OutputLabels(), tt.dump.outputLabels(); !reflect.DeepEqual(got, want) {
+				t.Errorf("OutputLabels() = %q, want %q", got, want)
+			}
+			if got, want := vh.Routing(), tt.dump.routing(); !reflect.DeepEqual(got, want) {
+				t.Errorf("Routing() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestBlockSplitAcrossWrites(t *testing.T) {
+	vh, device := pipeHub(t)
+	d := testDump{4, 4}
+	writeChunks(device, d.text("\n"), 64)
+	waitReady(t, vh)
+
+	// Split inside the header, inside a line and before the closing blank
+	// line; nothing may be applied until the block is complete.
+	for _, part := range []string{"VIDEO OUTPUT ROU", "TING:\n1 ", "2\n3 1\n", "\n"} {
+		if got, want := vh.Routing(), d.routing(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Routing() = %v before the block was complete, want %v", got, want)
+		}
+		if _, err := device.Write([]byte(part)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []int{3, 2, 1, 1}
+	eventually(t, "the split routing block", func() bool { return reflect.DeepEqual(vh.Routing(), want) })
+}
+
+func TestRoutingBlock(t *testing.T) {
+	vh, device := pipeHub(t)
+	writeChunks(device, testDump{12, 12}.text("\n"), 4096)
+	waitReady(t, vh)
+
+	block := "VIDEO OUTPUT ROUTING:\n" +
+		"0 5\n" +
+		"1 5\n" +
+		"2 11\n" +
+		"7 0\n" +
+		"11 3\n" +
+		"\n"
+	if _, err := device.Write([]byte(block)); err != nil {
+		t.Fatal(err)
+	}
+	want := []int{5, 5, 11, 8, 7, 6, 5, 0, 3, 2, 1, 3}
+	eventually(t, "the routing block", func() bool { return reflect.DeepEqual(vh.Routing(), want) })
+	if got := vh.Routing(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Routing() = %v, want %v", got, want)
+	}
+}
+
+// deviceBlock is a VIDEOHUB DEVICE block reporting inputs x outputs.
+func deviceBlock(uniqueID string, inputs, outputs int) string {
+	return fmt.Sprintf("VIDEOHUB DEVICE:\nDevice present: true\nModel name: Test\nUnique ID: %s\n"+
+		"Video inputs: %d\nVideo outputs: %d\n\n", uniqueID, inputs, outputs)
+}
+
+func TestDimensionsGrowAndShrink(t *testing.T) {
+	vh, device := pipeHub(t)
+	var handled [][4]int
+	vh.OnDimensionsChange(func(oldInputs, oldOutputs, inputs, outputs int) {
+		handled = append(handled, [4]int{oldInputs, oldOutputs, inputs, outputs})
+	})
+	events, cancel := vh.Subscribe()
+	defer cancel()
+	writeChunks(device, testDump{4, 4}.text("\n"), 4096)
+	waitReady(t, vh)
+
+	check := func(inputLabels, outputLabels []string, routing []int) {
+		t.Helper()
+		if got := vh.InputLabels(); !reflect.DeepEqual(got, inputLabels) {
+			t.Errorf("InputLabels() = %q, want %q", got, inputLabels)
+		}
+		if got := vh.OutputLabels(); !reflect.DeepEqual(got, outputLabels) {
+			t.Errorf("OutputLabels() = %q, want %q", got, outputLabels)
+		}
+		if got := vh.Routing(); !reflect.DeepEqual(got, routing) {
+			t.Errorf("Routing() = %v, want %v", got, routing)
+		}
+	}
+	nextDimensions := func() DimensionsChange {
+		t.Helper()
+		timeout := time.After(time.Second)
+		for {
+			select {
+			case ev := <-events:
+				if c, ok := ev.(DimensionsChange); ok {
+					return c
+				}
+			case <-timeout:
+				t.Fatal("no DimensionsChange event")
+			}
+		}
+	}
+
+	device.Write([]byte(deviceBlock("7C2E0DA4BFC0", 6, 6)))
+	if got, want := nextDimensions(), (DimensionsChange{4, 4, 6, 6}); got != want {
+		t.Errorf("grow: event %+v, want %+v", got, want)
+	}
+	check(
+		[]string{"Camera 1", "Camera 2", "Camera 3", "Camera 4", "", ""},
+		[]string{"Monitor 1", "Monitor 2", "Monitor 3", "Monitor 4", "", ""},
+		[]int{3, 2, 1, 0, -1, -1},
+	)
+
+	// Output 0 is fed by input 3, which goes away.
+	device.Write([]byte(deviceBlock("7C2E0DA4BFC0", 3, 2)))
+	if got, want := nextDimensions(), (DimensionsChange{6, 6, 3, 2}); got != want {
+		t.Errorf("shrink: event %+v, want %+v", got, want)
+	}
+	check(
+		[]string{"Camera 1", "Camera 2", "Camera 3"},
+		[]string{"Monitor 1", "Monitor 2"},
+		[]int{-1, 2},
+	)
+
+	// The same dimensions again are not a change.
+	device.Write([]byte(deviceBlock("7C2E0DA4BFC0", 3, 2)))
+	device.Write([]byte("VIDEO OUTPUT ROUTING:\n0 1\n\n"))
+	eventually(t, "the routing block", func() bool { return vh.RouteOf(0) == 1 })
+	if want := [][4]int{{4, 4, 6, 6}, {6, 6, 3, 2}}; !reflect.DeepEqual(handled, want) {
+		t.Errorf("OnDimensionsChange calls = %v, want %v", handled, want)
+	}
+	for len(events) > 0 {
+		if c, ok := (<-events).(DimensionsChange); ok {
+			t.Errorf("unexpected %+v", c)
+		}
+	}
+}
+
+func TestBlocksBeforeDeviceBlock(t *testing.T) {
+	vh, device := pipeHub(t)
+	device.Write([]byte("VIDEO OUTPUT ROUTING:\n5 2\n1 0\n\nINPUT LABELS:\n3 Camera 4\n\n"))
+	eventually(t, "the labels block", func() bool { return len(vh.InputLabels()) == 4 })
+	if got, want := vh.Routing(), []int{-1, 0, -1, -1, -1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Routing() = %v, want %v", got, want)
+	}
+	if got, want := vh.InputLabels(), []string{"", "", "", "Camera 4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("InputLabels() = %q, want %q", got, want)
+	}
+
+	// The device block then fixes the size.
+	device.Write([]byte(deviceBlock("7C2E0DA4BFC0", 8, 8)))
+	eventually(t, "the device block", func() bool { return len(vh.Routing()) == 8 })
+	if got, want := vh.Routing(), []int{-1, 0, -1, -1, -1, 2, -1, -1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Routing() = %v, want %v", got, want)
+	}
+}
+
+func TestIndexesBeyondMaxPortsDropped(t *testing.T) {
+	vh, device := pipeHub(t)
+	lines := []string{
+		fmt.Sprintf("%d 1", maxPorts),
+		fmt.Sprintf("%d 1", maxPorts*1000),
+		"99999999999999999999 1",
+		fmt.Sprintf("0 %d", maxPorts),
+		"-1 1",
+		"2 1",
+	}
+	device.Write([]byte("VIDEO OUTPUT ROUTING:\n" + strings.Join(lines, "\n") + "\n\n"))
+	device.Write([]byte(fmt.Sprintf("OUTPUT LABELS:\n%d Too far\n1 Monitor 2\n\n", maxPorts)))
+	eventually(t, "the labels block", func() bool { return len(vh.OutputLabels()) > 0 })
+	if got, want := vh.Routing(), []int{-1, -1, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Routing() = %v, want %v", got, want)
+	}
+	if got, want := vh.OutputLabels(), []string{"", "Monitor 2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("OutputLabels() = %q, want %q", got, want)
+	}
+}
+
+func TestNewVideohubWithConnRedialsSameAddress(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialed := make(chan string, 1)
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		dialed <- addr
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", addr)
+	}
+	vh, err := NewVideohubWithConn(conn, WithLogger(nil), WithDialer(dialer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vh.Close()
+	if got, want := vh.DeviceInfo().Address, "127.0.0.1"; got != want {
+		t.Errorf("DeviceInfo().Address = %q, want %q", got, want)
+	}
+
+	(<-accepted).Close()
+	select {
+	case addr := <-dialed:
+		if want := l.Addr().String(); addr != want {
+			t.Errorf("reconnect dialed %q, want %q", addr, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reconnect after the connection dropped")
+	}
+}
+
+func TestCloseDuringReconnect(t *testing.T) {
+	t.Run("dialing", func(t *testing.T) {
+		dialing := make(chan struct{}, 1)
+		hang := func(ctx context.Context, addr string) (net.Conn, error) {
+			dialing <- struct{}{}
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		vh, device := pipeHub(t, WithDialer(hang))
+		device.Close()
+		select {
+		case <-dialing:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reconnect attempt after the connection dropped")
+		}
+		assertClosesQuickly(t, vh)
+	})
+	t.Run("backing off", func(t *testing.T) {
+		attempts := make(chan struct{}, 16)
+		refuse := func(ctx context.Context, addr string) (net.Conn, error) {
+			attempts <- struct{}{}
+			return nil, errors.New("connection refused")
+		}
+		vh, device := pipeHub(t, WithDialer(refuse))
+		device.Close()
+		// After the second attempt the backoff is long enough to be
+		// sleeping in it.
+		for i := 0; i < 2; i++ {
+			select {
+			case <-attempts:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no reconnect attempt after the connection dropped")
+			}
+		}
+		assertClosesQuickly(t, vh)
+	})
+}
+
+func assertClosesQuickly(t *testing.T, vh *Videohub) {
+	t.Helper()
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		vh.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not return while reconnecting")
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Close took %v while reconnecting", elapsed)
+	}
+	if state := vh.connectionState(); state != StateDisconnected {
+		t.Errorf("state after Close = %v, want %v", state, StateDisconnected)
+	}
+}
+
+func TestSnapshotJSON(t *testing.T) {
+	vh, device := pipeHub(t)
+	writeChunks(device, testDump{2, 4}.text("\n")+
+		"VIDEO OUTPUT LOCKS:\n0 U\n1 O\n2 L\n3 U\n\nCONFIGURATION:\nTake Mode: true\n\n", 4096)
+	waitReady(t, vh)
+	eventually(t, "the configuration block", func() bool { return vh.GlobalTakeMode() })
+
+	got, err := json.Marshal(vh.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"protocolVersion":"2.7","model":"Blackmagic Universal Videohub 288","uniqueId":"7C2E0DA4BFC0",` +
+		`"inputs":2,"outputs":4,"inputLabels":["Camera 1","Camera 2"],` +
+		`"outputLabels":["Monitor 1","Monitor 2","Monitor 3","Monitor 4"],"routing":[1,0,1,0],` +
+		`"locks":["none","owned","locked","none"],"takeMode":true}`
+	if string(got) != want {
+		t.Errorf("Snapshot JSON =\n%s\nwant\n%s", got, want)
+	}
+
+	var snap Snapshot
+	if err := json.Unmarshal(got, &snap); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(snap, vh.Snapshot()) {
+		t.Errorf("Snapshot does not survive a JSON round trip: %+v", snap)
+	}
+}
+
+func TestConnectionHandlerSeesInitialConnect(t *testing.T) {
+	var mu sync.Mutex
+	var transitions [][2]ConnectionState
+	record := func(old, new ConnectionState) {
+		mu.Lock()
+		defer mu.Unlock()
+		transitions = append(transitions, [2]ConnectionState{old, new})
+	}
+	vh, device := pipeHub(t, WithConnectionHandler(record))
+	device.Close()
+	eventually(t, "reconnecting", func() bool { return vh.connectionState() == StateReconnecting })
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := [][2]ConnectionState{
+		{StateDisconnected, StateConnecting},
+		{StateConnecting, StateConnected},
+		{StateConnected, StateReconnecting},
+	}
+	if !reflect.DeepEqual(transitions, want) {
+		t.Errorf("transitions = %v, want %v", transitions, want)
+	}
+}
+
+// subscribers returns the number of active subscriptions.
+func subscribers(vh *Videohub) int {
+	vh.handlersMu.Lock()
+	defer vh.handlersMu.Unlock()
+	return len(vh.subscribers)
+}
+
+func TestWaitForChange(t *testing.T) {
+	vh, device := pipeHub(t)
+	writeChunks(device, testDump{4, 4}.text("\n"), 4096)
+	waitReady(t, vh)
+
+	type result struct {
+		ev  Event
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ev, err := vh.WaitForChange(context.Background(), func(ev Event) bool {
+			c, ok := ev.(RouteChange)
+			return ok && c.Destination == 2
+		})
+		done <- result{ev, err}
+	}()
+	eventually(t, "the subscription", func() bool { return subscribers(vh) == 1 })
+	device.Write([]byte("VIDEO OUTPUT ROUTING:\n0 0\n2 3\n\n"))
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		if c, ok := r.ev.(RouteChange); !ok || c.Destination != 2 || c.Source != 3 || c.Previous != 1 {
+			t.Errorf("WaitForChange = %+v, want the route change of output 2 from 1 to 3", r.ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("WaitForChange did not return on a matching event")
+	}
+	if n := subscribers(vh); n != 0 {
+		t.Errorf("%d subscriptions left after WaitForChange returned", n)
+	}
+}
+
+func TestWaitForChangeContextDone(t *testing.T) {
+	vh, device := pipeHub(t)
+	writeChunks(device, testDump{4, 4}.text("\n"), 4096)
+	waitReady(t, vh)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if ev, err := vh.WaitForChange(ctx, nil); !errors.Is(err, ErrTimeout) {
+		t.Errorf("WaitForChange past the deadline = %v, %v, want ErrTimeout", ev, err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if ev, err := vh.WaitForChange(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("WaitForChange with a cancelled ctx = %v, %v, want context.Canceled", ev, err)
+	}
+	if n := subscribers(vh); n != 0 {
+		t.Errorf("%d subscriptions left after WaitForChange returned", n)
+	}
+}
+
+func TestSubscribeDestinations(t *testing.T) {
+	vh, device := pipeHub(t)
+	writeChunks(device, testDump{4, 4}.text("\n"), 4096)
+	waitReady(t, vh)
+
+	changes, cancel := vh.SubscribeDestinations(1, 3)
+	device.Write([]byte("VIDEO OUTPUT ROUTING:\n0 0\n1 0\n2 0\n3 1\n\n"))
+	var got []RouteChange
+	for len(got) < 2 {
+		select {
+		case c := <-changes:
+			got = append(got, c)
+		case <-time.After(time.Second):
+			t.Fatalf("got %d route changes, want 2", len(got))
+		}
+	}
+	if got[0].Destination != 1 || got[0].Source != 0 || got[1].Destination != 3 || got[1].Source != 1 {
+		t.Errorf("route changes %+v, want outputs 1 and 3 only", got)
+	}
+
+	cancel()
+	for c := range changes {
+		t.Errorf("unexpected %+v after cancel", c)
+	}
+	if n := subscribers(vh); n != 0 {
+		t.Errorf("%d subscriptions left after cancel", n)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for a writer and a reader on different
+// goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestParseDump(t *testing.T) {
+	d := testDump{6, 4}
+	// Responses and a final block without its blank line are not state.
+	snap, err := ParseDump(strings.NewReader(d.text("\r\n") + "ACK\r\nVIDEO OUTPUT ROUTING:\r\n0 0\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Inputs != d.inputs || snap.Outputs != d.outputs || snap.UniqueID != "7C2E0DA4BFC0" {
+		t.Errorf("ParseDump() = %dx%d %q, want %dx%d %q", snap.Inputs, snap.Outputs, snap.UniqueID, d.inputs, d.outputs, "7C2E0DA4BFC0")
+	}
+	if !reflect.DeepEqual(snap.InputLabels, d.inputLabels()) || !reflect.DeepEqual(snap.OutputLabels, d.outputLabels()) {
+		t.Errorf("ParseDump() labels = %q, %q, want %q, %q", snap.InputLabels, snap.OutputLabels, d.inputLabels(), d.outputLabels())
+	}
+	if !reflect.DeepEqual(snap.Routing, d.routing()) {
+		t.Errorf("ParseDump() routing = %v, want %v", snap.Routing, d.routing())
+	}
+
+	if _, err := ParseDump(strings.NewReader("INPUT LABELS:\n0 Camera 1\n\n")); err == nil {
+		t.Error("ParseDump accepted a dump without a device block")
+	}
+}
+
+func TestWireCapture(t *testing.T) {
+	var capture syncBuffer
+	vh, device := pipeHub(t, WithWireCapture(&capture))
+	dump := testDump{4, 4}.text("\r\n")
+	writeChunks(device, dump, 13)
+	waitReady(t, vh)
+
+	if got := capture.String(); got != dump {
+		t.Errorf("capture =\n%q\nwant\n%q", got, dump)
+	}
+	snap, err := ParseDump(strings.NewReader(capture.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := vh.Snapshot(); !reflect.DeepEqual(snap, want) {
+		t.Errorf("ParseDump(capture) = %+v, want %+v", snap, want)
+	}
+}
+
+func TestLabelValidation(t *testing.T) {
+	// Nothing invalid may reach the device: the test never reads the pipe,
+	// so a label that is sent times out instead of failing validation.
+	vh, device := pipeHub(t, WithLabelCharset(ASCIICharset), WithCommandTimeout(50*time.Millisecond))
+	writeChunks(device, testDump{4, 4}.text("\n"), 4096)
+	waitReady(t, vh)
+
+	setters := []struct {
+		name string
+		set  func(label string) error
+	}{
+		{"InputLabel", func(label string) error { return vh.InputLabel(0, label) }},
+		{"OutputLabel", func(label string) error { return vh.OutputLabel(1, label) }},
+		{"InputLabelAsync", func(label string) error { return <-vh.InputLabelAsync(2, label) }},
+		{"OutputLabelAsync", func(label string) error { return <-vh.OutputLabelAsync(3, label) }},
+		{"BulkInputLabels", func(label string) error { return vh.BulkInputLabels(map[int]string{0: "Camera 1", 1: label}) }},
+		{"BulkOutputLabels", func(label string) error { return vh.BulkOutputLabels(map[int]string{2: label}) }},
+		{"ApplyConfig", func(label string) error {
+			_, err := vh.ApplyConfig(Config{OutputLabels: map[int]string{0: label}})
+			return err
+		}},
+		{"ApplyLabelTemplate", func(label string) error {
+			return vh.ApplyLabelTemplate(LabelTemplate{Input: func(int) string { return label }})
+		}},
+	}
+	labels := []struct {
+		name, label, reason string
+	}{
+		{"invalid UTF-8", "Camera \xff", "not valid UTF-8"},
+		{"line feed", "Camera\n1", "line breaks"},
+		{"carriage return", "Camera\r1", "line breaks"},
+		{"outside charset", "Caméra 1", "outside the label character set"},
+		{"control character", "Camera\t1", "outside the label character set"},
+	}
+	for _, setter := range setters {
+		for _, l := range labels {
+			t.Run(setter.name+"/"+l.name, func(t *testing.T) {
+				err := setter.set(l.label)
+				if !errors.Is(err, ErrInvalidLabel) {
+					t.Fatalf("%s(%q) = %v, want ErrInvalidLabel", setter.name, l.label, err)
+				}
+				// The template error lists the labels but not why.
+				if setter.name != "ApplyLabelTemplate" && !strings.Contains(err.Error(), l.reason) {
+					t.Errorf("%s(%q) = %q, want it to say %q", setter.name, l.label, err, l.reason)
+				}
+			})
+		}
+	}
+
+	// Without a charset only the encoding and line breaks are checked, and
+	// the Build functions never restrict the charset.
+	if _, err := BuildInputLabelCommand(0, "Caméra 1"); err != nil {
+		t.Errorf("BuildInputLabelCommand with a non-ASCII label: %v", err)
+	}
+}
+
+func TestPowerSupplyFaultBaseline(t *testing.T) {
+	vh, device := pipeHub(t)
+	faults := make(chan PowerSupply, 4)
+	vh.OnPowerSupplyFault(func(ps PowerSupply) { faults <- ps })
+	writeChunks(device, testDump{4, 4}.text("\n")+
+		"ALARM STATUS:\nPower supply 1: OK\nPower supply 2: Not present\n\n", 4096)
+	waitReady(t, vh)
+	eventually(t, "the alarm status block", func() bool { return len(vh.PowerSupplies()) == 2 })
+
+	device.Write([]byte("ALARM STATUS:\nPower supply 1: Fault\nPower supply 2: Not present\n\n"))
+	select {
+	case ps := <-faults:
+		if want := (PowerSupply{Index: 0, Present: true, OK: false, Status: "Fault"}); ps != want {
+			t.Errorf("fault %+v, want %+v", ps, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no fault reported for the supply that failed")
+	}
+	if len(faults) > 0 {
+		t.Errorf("unexpected fault %+v for a supply missing from the start", <-faults)
+	}
+}
+
+func TestCloseFromHandler(t *testing.T) {
+	vh, device := pipeHub(t)
+	closed := make(chan error, 1)
+	vh.OnRouteChange(func(destination, source int) {
+		closed <- vh.Close()
+	})
+	writeChunks(device, testDump{4, 4}.text("\n"), 4096)
+	waitReady(t, vh)
+
+	device.Write([]byte("VIDEO OUTPUT ROUTING:\n0 0\n\n"))
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("Close from a handler = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close called from OnRouteChange did not return")
+	}
+	assertClosesQuickly(t, vh)
+}
+
+func TestCapabilitiesFromDump(t *testing.T) {
+	for _, version := range []string{"2.7", "2.8"} {
+		t.Run(version, func(t *testing.T) {
+			vh, device := pipeHub(t)
+			// Neither a VIDEO OUTPUT LOCKS block nor a Friendly name.
+			dump := strings.Replace(testDump{2, 2}.text("\n"), "Version: 2.7", "Version: "+version, 1)
+			writeChunks(device, dump, 4096)
+			waitReady(t, vh)
+			if c := vh.Capabilities(); c.Locks || c.FriendlyName {
+				t.Errorf("Capabilities() = %+v, want neither Locks nor FriendlyName", c)
+			}
+
+			device.Write([]byte("VIDEO OUTPUT LOCKS:\n0 U\n1 L\n\n"))
+			eventually(t, "Locks", func() bool { return vh.Capabilities().Locks })
+			device.Write([]byte("VIDEOHUB DEVICE:\nFriendly name: Studio A\n\n"))
+			eventually(t, "FriendlyName", func() bool { return vh.Capabilities().FriendlyName })
+		})
+	}
+}